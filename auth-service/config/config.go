@@ -2,11 +2,78 @@ package config
 
 import "os"
 import "log"
+import "strconv"
+import "strings"
+import "time"
+import "encoding/json"
+
+import "authservice/model"
 
 type Config struct {
 	JWTSecretKey string
 	Port 		 string
 	DatabaseURL  string
+	// JWTSigningAlg is HS256, RS256 or EdDSA. The asymmetric algorithms sign
+	// with the PEM key at JWTPrivateKeyFile.
+	JWTSigningAlg     string
+	JWTPrivateKeyFile string
+	JWTKeyID          string
+	// JWTKeyRingFile lists several scheduled keys and takes precedence over
+	// the single key settings above. It is re-read every JWTKeyRingReload.
+	JWTKeyRingFile   string
+	JWTKeyRingReload time.Duration
+	// Issuer is the public base URL of the service, e.g. https://golang.medhelper.xyz
+	Issuer string
+	// TrustForwardedFor takes the client IP from X-Forwarded-For
+	TrustForwardedFor bool
+	// Failed logins allowed per email and per IP before a lockout starts,
+	// and the first and longest lockout
+	LoginMaxFailuresPerEmail int
+	LoginMaxFailuresPerIP    int
+	LoginLockoutBase         time.Duration
+	LoginLockoutMax          time.Duration
+	// The token reaper deletes refresh tokens, revocations, codes, magic
+	// links and login attempts every ReaperInterval, ReaperRetention after
+	// they expired or were revoked
+	ReaperEnabled   bool
+	ReaperInterval  time.Duration
+	ReaperRetention time.Duration
+	ReaperBatchSize int
+	// TokenPolicy holds the default token lifetimes and audience,
+	// ClientTokenPolicies the overrides of individual clients
+	TokenPolicy         model.TokenPolicy
+	ClientTokenPolicies map[string]model.TokenPolicy
+	// MagicLinkURL is the page emailed sign-in links point to
+	MagicLinkURL string
+	MagicLinkTTL time.Duration
+	// MailSender is "outbox", which writes mail to MailOutboxDir, or "smtp"
+	MailSender    string
+	MailFrom      string
+	MailOutboxDir string
+	SMTPHost      string
+	SMTPPort      int
+	SMTPUsername  string
+	SMTPPassword  string
+	// DeviceVerificationURL is the page users enter device user codes on
+	DeviceVerificationURL string
+	DeviceCodeTTL         time.Duration
+	// ImpersonationTTL caps the lifetime of tokens issued to support agents
+	// acting as a player
+	ImpersonationTTL time.Duration
+	// On SIGTERM the readiness probe fails for ShutdownDelay before the
+	// server stops accepting connections, then in-flight requests get
+	// ShutdownTimeout to finish
+	ShutdownDelay   time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// clientTokenPolicy is one entry of CLIENT_TOKEN_POLICIES, for example
+//
+//	{"web": {"access_token_ttl": "15m", "refresh_token_ttl": "168h", "audience": ["bets-api"]}}
+type clientTokenPolicy struct {
+	AccessTokenTTL  string   `json:"access_token_ttl"`
+	RefreshTokenTTL string   `json:"refresh_token_ttl"`
+	Audience        []string `json:"audience"`
 }
 
 func New() *Config {
@@ -25,9 +92,151 @@ func New() *Config {
 		log.Fatal("JWT_SECRET_KEY environment variable is required")
 	}
 
+	signingAlg := os.Getenv("JWT_SIGNING_ALG")
+	if signingAlg == "" {
+		signingAlg = "HS256"
+	}
+
+	keyRingFile := os.Getenv("JWT_KEY_RING_FILE")
+
+	keyRingReload := durationEnv("JWT_KEY_RING_RELOAD", time.Minute)
+
+	privateKeyFile := os.Getenv("JWT_PRIVATE_KEY_FILE")
+	if keyRingFile == "" && signingAlg != "HS256" && privateKeyFile == "" {
+		log.Fatal("JWT_PRIVATE_KEY_FILE environment variable is required for " + signingAlg)
+	}
+
+	issuer := os.Getenv("ISSUER_URL")
+	if issuer == "" {
+		issuer = "http://golang.medhelper.xyz"
+	}
+
+	magicLinkURL := stringEnv("MAGIC_LINK_URL", strings.TrimSuffix(issuer, "/")+"/magic-link/verify")
+
+	mailSender := stringEnv("MAIL_SENDER", "outbox")
+	smtpHost := os.Getenv("SMTP_HOST")
+	if mailSender != "outbox" && mailSender != "smtp" {
+		log.Fatal("MAIL_SENDER must be outbox or smtp")
+	}
+	if mailSender == "smtp" && smtpHost == "" {
+		log.Fatal("SMTP_HOST environment variable is required for MAIL_SENDER=smtp")
+	}
+
 	return &Config{
-		JWTSecretKey: JWT,
-		Port:         port,
-		DatabaseURL:  dbURL,
+		JWTSecretKey:      JWT,
+		Port:              port,
+		DatabaseURL:       dbURL,
+		JWTSigningAlg:     signingAlg,
+		JWTPrivateKeyFile: privateKeyFile,
+		JWTKeyID:          os.Getenv("JWT_KEY_ID"),
+		JWTKeyRingFile:    keyRingFile,
+		JWTKeyRingReload:  keyRingReload,
+		Issuer:            issuer,
+		TrustForwardedFor: os.Getenv("TRUST_FORWARDED_FOR") == "true",
+
+		LoginMaxFailuresPerEmail: intEnv("LOGIN_MAX_FAILURES_PER_EMAIL", 5),
+		LoginMaxFailuresPerIP:    intEnv("LOGIN_MAX_FAILURES_PER_IP", 50),
+		LoginLockoutBase:         durationEnv("LOGIN_LOCKOUT_BASE", 30*time.Second),
+		LoginLockoutMax:          durationEnv("LOGIN_LOCKOUT_MAX", time.Hour),
+
+		ReaperEnabled:   os.Getenv("REAPER_ENABLED") != "false",
+		ReaperInterval:  durationEnv("REAPER_INTERVAL", 10*time.Minute),
+		ReaperRetention: durationEnv("REAPER_RETENTION", 24*time.Hour),
+		ReaperBatchSize: intEnv("REAPER_BATCH_SIZE", 1000),
+
+		TokenPolicy: model.TokenPolicy{
+			AccessTokenTTL:  durationEnv("ACCESS_TOKEN_TTL", 75*time.Minute),
+			RefreshTokenTTL: durationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+			Audience:        listEnv("TOKEN_AUDIENCE"),
+		},
+		ClientTokenPolicies: clientTokenPolicies(),
+
+		MagicLinkURL:  magicLinkURL,
+		MagicLinkTTL:  durationEnv("MAGIC_LINK_TTL", 15*time.Minute),
+		MailSender:    mailSender,
+		MailFrom:      stringEnv("MAIL_FROM", "no-reply@golang.medhelper.xyz"),
+		MailOutboxDir: stringEnv("MAIL_OUTBOX_DIR", "/tmp/auth-service-outbox"),
+		SMTPHost:      smtpHost,
+		SMTPPort:      intEnv("SMTP_PORT", 587),
+		SMTPUsername:  os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:  os.Getenv("SMTP_PASSWORD"),
+
+		DeviceVerificationURL: stringEnv("DEVICE_VERIFICATION_URL", strings.TrimSuffix(issuer, "/")+"/device"),
+		DeviceCodeTTL:         durationEnv("DEVICE_CODE_TTL", 10*time.Minute),
+
+		ImpersonationTTL: durationEnv("IMPERSONATION_TTL", 15*time.Minute),
+
+		ShutdownDelay:   durationEnv("SHUTDOWN_DELAY", 5*time.Second),
+		ShutdownTimeout: durationEnv("SHUTDOWN_TIMEOUT", 20*time.Second),
+	}
+}
+
+func stringEnv(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
 	}
-}
\ No newline at end of file
+	return fallback
+}
+
+func clientTokenPolicies() map[string]model.TokenPolicy {
+	policies := make(map[string]model.TokenPolicy)
+	v := os.Getenv("CLIENT_TOKEN_POLICIES")
+	if v == "" {
+		return policies
+	}
+	var entries map[string]clientTokenPolicy
+	if err := json.Unmarshal([]byte(v), &entries); err != nil {
+		log.Fatalf("CLIENT_TOKEN_POLICIES is not valid JSON: %v", err)
+	}
+	for clientID, entry := range entries {
+		policy := model.TokenPolicy{Audience: entry.Audience}
+		var err error
+		if entry.AccessTokenTTL != "" {
+			if policy.AccessTokenTTL, err = time.ParseDuration(entry.AccessTokenTTL); err != nil || policy.AccessTokenTTL <= 0 {
+				log.Fatalf("CLIENT_TOKEN_POLICIES: %s: access_token_ttl must be a positive duration", clientID)
+			}
+		}
+		if entry.RefreshTokenTTL != "" {
+			if policy.RefreshTokenTTL, err = time.ParseDuration(entry.RefreshTokenTTL); err != nil || policy.RefreshTokenTTL <= 0 {
+				log.Fatalf("CLIENT_TOKEN_POLICIES: %s: refresh_token_ttl must be a positive duration", clientID)
+			}
+		}
+		policies[clientID] = policy
+	}
+	return policies
+}
+
+// listEnv splits a comma separated variable, dropping empty entries
+func listEnv(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatal(name + " must be a positive duration")
+	}
+	return d
+}
+
+func intEnv(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatal(name + " must be a positive integer")
+	}
+	return n
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"authservice/model"
+	"authservice/service"
+)
+
+// HandleAuditEvents returns audit log entries, newest first. They can be
+// filtered by user_id, email, type and a from/to time range (RFC 3339);
+// before and limit page through the results.
+func (c *TokenController) HandleAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	query := r.URL.Query()
+	filter := model.AuditFilter{
+		Email: query.Get("email"),
+		Type:  query.Get("type"),
+	}
+	var err error
+	if v := query.Get("user_id"); v != "" {
+		if filter.UserID, err = strconv.Atoi(v); err != nil {
+			respondWithError(w, "user_id must be a number", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			respondWithError(w, "from must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			respondWithError(w, "to must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("before"); v != "" {
+		if filter.BeforeID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			respondWithError(w, "before must be a number", http.StatusBadRequest)
+			return
+		}
+	}
+	filter.Limit = 100
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			respondWithError(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := service.ListAuditEvents(ctx, filter)
+	if err != nil {
+		log.Printf("audit query failed: %v", err)
+		respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{"events": events}
+	if len(events) > 0 && len(events) == min(filter.Limit, service.MaxAuditEvents) {
+		response["next_before"] = events[len(events)-1].ID
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, response, http.StatusOK)
+}
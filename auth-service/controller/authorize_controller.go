@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"authservice/middleware"
+	"authservice/model"
+	"authservice/service"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+{{if .Request}}
+<form method="POST" action="/authorize">
+  <input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+  <input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+  <input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+  <input type="hidden" name="scope" value="{{.Request.Scope}}">
+  <input type="hidden" name="state" value="{{.Request.State}}">
+  <input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+  <input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+  <input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+  <label>Email <input type="email" name="email" required></label>
+  <label>Password <input type="password" name="password" required></label>
+  <button type="submit">Sign in</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type loginPageData struct {
+	Request *model.AuthorizationRequest
+	Error   string
+}
+
+// HandleAuthorize implements the authorization endpoint of the
+// authorization-code flow with PKCE. GET shows the login form, POST checks
+// the credentials and redirects back to the client with a code.
+func (c *TokenController) HandleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	if err := r.ParseForm(); err != nil {
+		renderLoginPage(w, loginPageData{Error: "Bad request"}, http.StatusBadRequest)
+		return
+	}
+
+	req := &model.AuthorizationRequest{
+		ResponseType:        r.FormValue("response_type"),
+		ClientID:            r.FormValue("client_id"),
+		RedirectURI:         r.FormValue("redirect_uri"),
+		Scope:               r.FormValue("scope"),
+		State:               r.FormValue("state"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+		Nonce:               r.FormValue("nonce"),
+	}
+
+	if r.Method == http.MethodGet {
+		if _, err := c.tokenService.ValidateAuthorizationRequest(ctx, req); err != nil {
+			c.handleAuthorizeError(w, r, req, err)
+			return
+		}
+		renderLoginPage(w, loginPageData{Request: req}, http.StatusOK)
+		return
+	}
+
+	email := r.PostFormValue("email")
+	ip := middleware.ClientIP(r)
+	if retryAfter, err := c.loginThrottle.Check(ctx, email, ip); err != nil {
+		c.handleAuthorizeError(w, r, req, err)
+		return
+	} else if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		renderLoginPage(w, loginPageData{Request: req, Error: "Too many failed login attempts, try again later"}, http.StatusTooManyRequests)
+		return
+	}
+
+	code, err := c.tokenService.Authorize(ctx, req, email, r.PostFormValue("password"), requestInfo(r))
+	c.recordLoginResult(ctx, email, ip, err)
+	if err != nil {
+		if err == service.ErrInvalidCredentials {
+			renderLoginPage(w, loginPageData{Request: req, Error: "Invalid email or password"}, http.StatusUnauthorized)
+			return
+		}
+		c.handleAuthorizeError(w, r, req, err)
+		return
+	}
+
+	redirectToClient(w, r, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+// handleAuthorizeError reports errors to the client through its
+// redirect_uri, except when the client or redirect_uri itself is invalid
+func (c *TokenController) handleAuthorizeError(w http.ResponseWriter, r *http.Request, req *model.AuthorizationRequest, err error) {
+	var code string
+	switch err {
+	case service.ErrInvalidClient:
+		renderLoginPage(w, loginPageData{Error: "Unknown client"}, http.StatusBadRequest)
+		return
+	case service.ErrInvalidRedirectURI:
+		renderLoginPage(w, loginPageData{Error: "Invalid redirect_uri"}, http.StatusBadRequest)
+		return
+	case service.ErrUnsupportedResponseType:
+		code = "unsupported_response_type"
+	case service.ErrPKCERequired:
+		code = "invalid_request"
+	case service.ErrInvalidScope:
+		code = "invalid_scope"
+	default:
+		log.Printf("authorization request failed: %v", err)
+		code = "server_error"
+	}
+	redirectToClient(w, r, req.RedirectURI, url.Values{"error": {code}, "state": {req.State}})
+}
+
+func redirectToClient(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		renderLoginPage(w, loginPageData{Error: "Invalid redirect_uri"}, http.StatusBadRequest)
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		if values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func renderLoginPage(w http.ResponseWriter, data loginPageData, code int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(code)
+	if err := loginPage.Execute(w, data); err != nil {
+		log.Printf("Error rendering login page: %v", err)
+	}
+}
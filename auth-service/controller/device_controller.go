@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"authservice/middleware"
+	"authservice/model"
+	"authservice/service"
+)
+
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+{{if .Done}}<p>{{.Done}}</p>{{else}}
+{{if .Device}}<p>{{.Device.ClientID}} asks for access{{if .Device.Scope}} to: {{.Device.Scope}}{{end}}.</p>{{end}}
+<form method="POST" action="/device">
+  <label>Code <input type="text" name="user_code" value="{{.UserCode}}" autocomplete="off" required></label>
+  <label>Email <input type="email" name="email" required></label>
+  <label>Password <input type="password" name="password" required></label>
+  <button type="submit" name="action" value="approve">Allow</button>
+  <button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type devicePageData struct {
+	UserCode string
+	Device   *model.DeviceCode
+	Error    string
+	Done     string
+}
+
+// HandleDeviceAuthorization is the device authorization endpoint
+// (RFC 8628 section 3.1). The device shows the returned user code and polls
+// /token with the device code.
+func (c *TokenController) HandleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithOAuthError(w, "invalid_request", "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	if err := parseTokenRequest(w, r); err != nil {
+		respondWithOAuthError(w, "invalid_request", "Malformed request body", http.StatusBadRequest)
+		return
+	}
+
+	clientID, clientSecret, usedBasic := clientCredentials(r)
+	if clientID == "" {
+		respondWithOAuthError(w, "invalid_request", "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+
+	response, err := c.devices.StartDeviceAuthorization(ctx, clientID, clientSecret, r.FormValue("scope"))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidClient:
+			respondInvalidClient(w, usedBasic)
+		case service.ErrInvalidScope:
+			respondWithOAuthError(w, "invalid_scope", "Invalid scope", http.StatusBadRequest)
+		default:
+			log.Printf("device authorization failed: %v", err)
+			respondWithOAuthError(w, "server_error", "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	respondWithJSON(w, response, http.StatusOK)
+}
+
+// HandleDeviceVerification is the page users enter the user code on. GET
+// shows which client is asking, POST logs the user in and allows or denies
+// the device.
+func (c *TokenController) HandleDeviceVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	if err := r.ParseForm(); err != nil {
+		renderDevicePage(w, devicePageData{Error: "Bad request"}, http.StatusBadRequest)
+		return
+	}
+
+	data := devicePageData{UserCode: r.FormValue("user_code")}
+	if r.Method == http.MethodGet {
+		if data.UserCode == "" {
+			renderDevicePage(w, data, http.StatusOK)
+			return
+		}
+		device, err := c.devices.PendingDevice(ctx, data.UserCode)
+		if err != nil {
+			c.handleDeviceError(w, data, err)
+			return
+		}
+		data.Device = device
+		renderDevicePage(w, data, http.StatusOK)
+		return
+	}
+
+	email := r.PostFormValue("email")
+	ip := middleware.ClientIP(r)
+	if retryAfter, err := c.loginThrottle.Check(ctx, email, ip); err != nil {
+		c.handleDeviceError(w, data, err)
+		return
+	} else if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		data.Error = "Too many failed login attempts, try again later"
+		renderDevicePage(w, data, http.StatusTooManyRequests)
+		return
+	}
+
+	approve := r.PostFormValue("action") == "approve"
+	err := c.devices.ResolveDevice(ctx, data.UserCode, email, r.PostFormValue("password"), approve, requestInfo(r))
+	c.recordLoginResult(ctx, email, ip, err)
+	if err != nil {
+		c.handleDeviceError(w, data, err)
+		return
+	}
+
+	if approve {
+		data.Done = "The device is connected. You can return to it now."
+	} else {
+		data.Done = "The device was denied access."
+	}
+	renderDevicePage(w, data, http.StatusOK)
+}
+
+func (c *TokenController) handleDeviceError(w http.ResponseWriter, data devicePageData, err error) {
+	switch err {
+	case service.ErrInvalidUserCode:
+		data.Error = "The code is invalid or has expired"
+		renderDevicePage(w, data, http.StatusBadRequest)
+	case service.ErrInvalidCredentials:
+		data.Error = "Invalid email or password"
+		renderDevicePage(w, data, http.StatusUnauthorized)
+	default:
+		log.Printf("device verification failed: %v", err)
+		data.Error = "Internal server error"
+		renderDevicePage(w, data, http.StatusInternalServerError)
+	}
+}
+
+func (c *TokenController) handleDeviceCodeGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, usedBasic := clientCredentials(r)
+	deviceCode := r.FormValue("device_code")
+
+	if clientID == "" || deviceCode == "" {
+		respondWithOAuthError(w, "invalid_request", "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+
+	response, err := c.devices.ExchangeDeviceCode(ctx, clientID, clientSecret, deviceCode, requestInfo(r))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidClient:
+			respondInvalidClient(w, usedBasic)
+		case service.ErrAuthorizationPending:
+			respondWithOAuthError(w, "authorization_pending", "The user has not approved the device yet", http.StatusBadRequest)
+		case service.ErrSlowDown:
+			respondWithOAuthError(w, "slow_down", "Polling too often, wait 5 seconds longer", http.StatusBadRequest)
+		case service.ErrAccessDenied:
+			respondWithOAuthError(w, "access_denied", "The user denied the device", http.StatusBadRequest)
+		case service.ErrDeviceCodeExpired:
+			respondWithOAuthError(w, "expired_token", "Device code expired", http.StatusBadRequest)
+		case service.ErrInvalidGrant:
+			respondWithOAuthError(w, "invalid_grant", "Invalid device code", http.StatusBadRequest)
+		default:
+			log.Printf("device code grant failed: %v", err)
+			respondWithOAuthError(w, "server_error", "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondWithToken(w, response)
+}
+
+func renderDevicePage(w http.ResponseWriter, data devicePageData, code int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(code)
+	if err := devicePage.Execute(w, data); err != nil {
+		log.Printf("Error rendering device page: %v", err)
+	}
+}
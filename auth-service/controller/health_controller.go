@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Pinger checks that a dependency can be reached. *sql.DB implements it.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// HealthController serves the liveness and readiness probes
+type HealthController struct {
+	db       Pinger
+	draining atomic.Bool
+}
+
+// NewHealthController creates a HealthController that reports ready while db
+// answers
+func NewHealthController(db Pinger) *HealthController {
+	return &HealthController{db: db}
+}
+
+// Drain makes the readiness probe fail so the load balancer stops sending
+// requests before the server shuts down
+func (c *HealthController) Drain() {
+	c.draining.Store(true)
+}
+
+// HandleLiveness reports that the process is running. It checks nothing
+// else so a slow database does not get the pod restarted.
+func (c *HealthController) HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, map[string]string{"status": "ok"}, http.StatusOK)
+}
+
+// HandleReadiness reports whether the pod should receive traffic: it is not
+// shutting down and Postgres answers
+func (c *HealthController) HandleReadiness(w http.ResponseWriter, r *http.Request) {
+	if c.draining.Load() {
+		respondWithJSON(w, map[string]string{"status": "draining"}, http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	if err := c.db.PingContext(ctx); err != nil {
+		log.Printf("readiness check failed: %v", err)
+		respondWithJSON(w, map[string]string{"status": "database unavailable"}, http.StatusServiceUnavailable)
+		return
+	}
+	respondWithJSON(w, map[string]string{"status": "ok"}, http.StatusOK)
+}
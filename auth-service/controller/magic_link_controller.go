@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"authservice/middleware"
+	"authservice/service"
+)
+
+// magicLinkPage asks for a click before the link is redeemed. Mail scanners
+// that prefetch links only issue GETs, so they cannot use up the link.
+var magicLinkPage = template.Must(template.New("magic-link").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<form method="POST" action="/magic-link/verify">
+  <input type="hidden" name="token" value="{{.}}">
+  <button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// HandleMagicLinkRequest emails a sign-in link. It answers the same way
+// whether or not the address has an account.
+func (c *TokenController) HandleMagicLinkRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	if err := parseTokenRequest(w, r); err != nil {
+		respondWithError(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	email := r.FormValue("email")
+	if email == "" {
+		respondWithError(w, "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+
+	if retryAfter, err := c.loginThrottle.Check(ctx, email, middleware.ClientIP(r)); err != nil {
+		log.Printf("login throttle check failed: %v", err)
+		respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	} else if retryAfter > 0 {
+		respondLocked(w, retryAfter)
+		return
+	}
+
+	err := c.magicLinks.SendMagicLink(ctx, email, r.FormValue("client_id"), r.FormValue("scope"))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidScope:
+			respondWithError(w, "Invalid scope", http.StatusBadRequest)
+		case service.ErrInvalidClient:
+			respondWithError(w, "Unknown client", http.StatusBadRequest)
+		default:
+			log.Printf("magic link request failed: %v", err)
+			respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondWithJSON(w, map[string]string{"message": "If the address has an account, a sign-in link has been sent"}, http.StatusAccepted)
+}
+
+// HandleMagicLinkVerify redeems a sign-in link. GET shows a confirmation
+// button, POST returns the token response.
+func (c *TokenController) HandleMagicLinkVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	if err := parseTokenRequest(w, r); err != nil {
+		respondWithOAuthError(w, "invalid_request", "Malformed request body", http.StatusBadRequest)
+		return
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		respondWithOAuthError(w, "invalid_request", "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		if err := magicLinkPage.Execute(w, token); err != nil {
+			log.Printf("magic link page render failed: %v", err)
+		}
+		return
+	}
+
+	response, err := c.magicLinks.ExchangeMagicLink(ctx, token, requestInfo(r))
+	if err != nil {
+		switch err {
+		case service.ErrMagicLinkInvalid:
+			respondWithOAuthError(w, "invalid_grant", "Invalid or expired link", http.StatusBadRequest)
+		default:
+			log.Printf("magic link exchange failed: %v", err)
+			respondWithOAuthError(w, "server_error", "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondWithToken(w, response)
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"authservice/service"
+)
+
+// HandleSessions lists (GET) or revokes (DELETE) the refresh token sessions
+// of the caller. DELETE takes an optional id and revokes every session
+// without it. Tokens with the sessions:admin scope may pass email to act on
+// another user.
+func (c *TokenController) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="auth-service"`)
+		respondWithError(w, "Authorization header missing or invalid", http.StatusUnauthorized)
+		return
+	}
+	email := r.URL.Query().Get("email")
+
+	if r.Method == http.MethodGet {
+		sessions, err := c.tokenService.ListSessions(ctx, token, email)
+		if err != nil {
+			handleSessionError(w, err)
+			return
+		}
+		respondWithJSON(w, map[string]interface{}{"sessions": sessions}, http.StatusOK)
+		return
+	}
+
+	revoked, err := c.tokenService.RevokeSessions(ctx, token, email, r.URL.Query().Get("id"), requestInfo(r))
+	if err != nil {
+		handleSessionError(w, err)
+		return
+	}
+	respondWithJSON(w, map[string]int{"revoked": revoked}, http.StatusOK)
+}
+
+func handleSessionError(w http.ResponseWriter, err error) {
+	switch err {
+	case service.ErrInvalidToken, service.ErrTokenExpired, service.ErrTokenRevoked:
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		respondWithError(w, "Invalid token", http.StatusUnauthorized)
+	case service.ErrInvalidScope:
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		respondWithError(w, "Insufficient scope", http.StatusForbidden)
+	case service.ErrSessionNotFound:
+		respondWithError(w, "Session not found", http.StatusNotFound)
+	default:
+		log.Printf("session request failed: %v", err)
+		respondWithError(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -3,100 +3,435 @@ package controller
 import (
   "context"
   "encoding/json"
+  "log"
+  "math"
+  "mime"
   "net/http"
+  "net/url"
+  "strconv"
+  "strings"
   "time"
 	
+  "authservice/middleware"
+  "authservice/model"
   "authservice/service"
 )
 
 // TokenController handles HTTP requests related to tokens
 type TokenController struct {
-	tokenService *service.TokenService
+	tokenService  *service.TokenService
+	loginThrottle *service.LoginThrottle
+	magicLinks    *service.MagicLinkService
+	devices       *service.DeviceService
+	impersonation *service.ImpersonationService
 }
 
 // NewTokenController creates a new TokenController
-func NewTokenController(tokenService *service.TokenService) *TokenController {
+func NewTokenController(tokenService *service.TokenService, loginThrottle *service.LoginThrottle, magicLinks *service.MagicLinkService, devices *service.DeviceService, impersonation *service.ImpersonationService) *TokenController {
 	return &TokenController{
-		tokenService: tokenService,
+		tokenService:  tokenService,
+		loginThrottle: loginThrottle,
+		magicLinks:    magicLinks,
+		devices:       devices,
+		impersonation: impersonation,
 	}
 }
 
-// HandleTokenRequest handles token creation requests
+// HandleTokenRequest handles token creation requests. Parameters may be sent
+// as a form or as a JSON object; errors use the RFC 6749 format.
 func (c *TokenController) HandleTokenRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondWithOAuthError(w, "invalid_request", "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 	defer cancel()
 	
-	if err := r.ParseForm(); err != nil {
-		respondWithError(w, "Bad request", http.StatusBadRequest)
+	if err := parseTokenRequest(w, r); err != nil {
+		respondWithOAuthError(w, "invalid_request", "Malformed request body", http.StatusBadRequest)
 		return
 	}
 	
+	switch r.FormValue("grant_type") {
+	case "", "password":
+		c.handlePasswordGrant(ctx, w, r)
+	case "refresh_token":
+		c.handleRefreshTokenGrant(ctx, w, r)
+	case "client_credentials":
+		c.handleClientCredentialsGrant(ctx, w, r)
+	case "authorization_code":
+		c.handleAuthorizationCodeGrant(ctx, w, r)
+	case service.DeviceCodeGrantType:
+		c.handleDeviceCodeGrant(ctx, w, r)
+	case service.TokenExchangeGrantType:
+		c.handleTokenExchangeGrant(ctx, w, r)
+	default:
+		respondWithOAuthError(w, "unsupported_grant_type", "Unsupported grant type", http.StatusBadRequest)
+	}
+}
+
+func (c *TokenController) handlePasswordGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
 	password := r.FormValue("password")
 	
 	if email == "" || password == "" {
-		respondWithError(w, "Missing required parameters", http.StatusBadRequest)
+		respondWithOAuthError(w, "invalid_request", "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+
+	ip := middleware.ClientIP(r)
+	if retryAfter, err := c.loginThrottle.Check(ctx, email, ip); err != nil {
+		log.Printf("login throttle check failed: %v", err)
+		respondWithOAuthError(w, "server_error", "Internal server error", http.StatusInternalServerError)
+		return
+	} else if retryAfter > 0 {
+		respondLocked(w, retryAfter)
 		return
 	}
 	
 	// Create token
-	response, err := c.tokenService.CreateToken(ctx, email, password)
+	clientID, clientSecret, usedBasic := clientCredentials(r)
+	response, err := c.tokenService.CreateToken(ctx, email, password, clientID, clientSecret, r.FormValue("scope"), requestInfo(r))
+	c.recordLoginResult(ctx, email, ip, err)
 	if err != nil {
 		switch err {
+		case service.ErrInvalidClient:
+			respondInvalidClient(w, usedBasic)
 		case service.ErrInvalidCredentials:
-			respondWithError(w, "Invalid credentials", http.StatusUnauthorized)
+			respondWithOAuthError(w, "invalid_grant", "Invalid credentials", http.StatusBadRequest)
+		case service.ErrInvalidScope:
+			respondWithOAuthError(w, "invalid_scope", "Invalid scope", http.StatusBadRequest)
 		default:
-			respondWithError(w, "Internal server error", http.StatusInternalServerError)
+			log.Printf("password grant failed: %v", err)
+			respondWithOAuthError(w, "server_error", "Internal server error", http.StatusInternalServerError)
 		}
 		return
 	}
 	
+	respondWithToken(w, response)
+}
+
+func (c *TokenController) handleRefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.FormValue("refresh_token")
+	if refreshToken == "" {
+		respondWithOAuthError(w, "invalid_request", "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+
+	clientID, clientSecret, usedBasic := clientCredentials(r)
+	response, err := c.tokenService.RefreshToken(ctx, refreshToken, clientID, clientSecret, requestInfo(r))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidClient:
+			respondInvalidClient(w, usedBasic)
+		case service.ErrClientMismatch:
+			respondWithOAuthError(w, "invalid_grant", "Token was issued to another client", http.StatusBadRequest)
+		case service.ErrTokenNotFound:
+			respondWithOAuthError(w, "invalid_grant", "Token not found", http.StatusBadRequest)
+		case service.ErrTokenExpired:
+			respondWithOAuthError(w, "invalid_grant", "Token expired", http.StatusBadRequest)
+		case service.ErrTokenRevoked, service.ErrTokenReused:
+			respondWithOAuthError(w, "invalid_grant", "Token revoked", http.StatusBadRequest)
+		default:
+			log.Printf("refresh token grant failed: %v", err)
+			respondWithOAuthError(w, "server_error", "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondWithToken(w, response)
+}
+
+func (c *TokenController) handleClientCredentialsGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, usedBasic := clientCredentials(r)
+	if clientID == "" || clientSecret == "" {
+		respondWithOAuthError(w, "invalid_client", "Missing client credentials", http.StatusUnauthorized)
+		return
+	}
+
+	response, err := c.tokenService.ClientCredentials(ctx, clientID, clientSecret, r.FormValue("scope"), requestInfo(r))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidClient:
+			respondInvalidClient(w, usedBasic)
+		case service.ErrInvalidScope:
+			respondWithOAuthError(w, "invalid_scope", "Invalid scope", http.StatusBadRequest)
+		default:
+			log.Printf("client credentials grant failed: %v", err)
+			respondWithOAuthError(w, "server_error", "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondWithToken(w, response)
+}
+
+func (c *TokenController) handleAuthorizationCodeGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, usedBasic := clientCredentials(r)
+	code := r.FormValue("code")
+	redirectURI := r.FormValue("redirect_uri")
+	codeVerifier := r.FormValue("code_verifier")
+
+	if clientID == "" || code == "" || redirectURI == "" || codeVerifier == "" {
+		respondWithOAuthError(w, "invalid_request", "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+
+	response, err := c.tokenService.ExchangeAuthorizationCode(ctx, clientID, clientSecret, code, redirectURI, codeVerifier, requestInfo(r))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidClient:
+			respondInvalidClient(w, usedBasic)
+		case service.ErrInvalidGrant:
+			respondWithOAuthError(w, "invalid_grant", "Invalid authorization code", http.StatusBadRequest)
+		default:
+			log.Printf("authorization code grant failed: %v", err)
+			respondWithOAuthError(w, "server_error", "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondWithToken(w, response)
+}
+
+// handleTokenExchangeGrant lets a support agent act as a player (RFC 8693).
+// subject_token is the agent's access token and requested_subject the
+// player's email; reason is kept in the audit trail.
+func (c *TokenController) handleTokenExchangeGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	subjectToken := r.FormValue("subject_token")
+	requestedSubject := r.FormValue("requested_subject")
+
+	if subjectToken == "" || requestedSubject == "" {
+		respondWithOAuthError(w, "invalid_request", "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("subject_token_type") != service.AccessTokenType {
+		respondWithOAuthError(w, "invalid_request", "subject_token_type must be "+service.AccessTokenType, http.StatusBadRequest)
+		return
+	}
+	if tokenType := r.FormValue("requested_token_type"); tokenType != "" && tokenType != service.AccessTokenType {
+		respondWithOAuthError(w, "invalid_request", "Only access tokens can be requested", http.StatusBadRequest)
+		return
+	}
+
+	response, err := c.impersonation.Impersonate(ctx, subjectToken, requestedSubject, r.FormValue("scope"), r.FormValue("reason"), requestInfo(r))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidGrant:
+			respondWithOAuthError(w, "invalid_grant", "Invalid subject token", http.StatusBadRequest)
+		case service.ErrImpersonationForbidden:
+			respondWithOAuthError(w, "invalid_grant", "The subject token may not impersonate users", http.StatusBadRequest)
+		case service.ErrInvalidTarget:
+			respondWithOAuthError(w, "invalid_target", "The requested subject cannot be impersonated", http.StatusBadRequest)
+		case service.ErrInvalidScope:
+			respondWithOAuthError(w, "invalid_scope", "Invalid scope", http.StatusBadRequest)
+		default:
+			log.Printf("token exchange grant failed: %v", err)
+			respondWithOAuthError(w, "server_error", "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondWithToken(w, response)
+}
+
+// parseTokenRequest fills r.Form from the body. JSON objects are accepted
+// besides forms as long as every value is a string.
+func parseTokenRequest(w http.ResponseWriter, r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return r.ParseForm()
+	}
+
+	var params map[string]string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&params); err != nil {
+		return err
+	}
+	r.Form = make(url.Values, len(params))
+	for key, value := range params {
+		r.Form.Set(key, value)
+	}
+	r.PostForm = r.Form
+	return nil
+}
+
+// recordLoginResult feeds the outcome of a password check to the throttle.
+// Errors other than bad credentials say nothing about the password.
+func (c *TokenController) recordLoginResult(ctx context.Context, email, ip string, err error) {
+	var recordErr error
+	switch err {
+	case nil:
+		recordErr = c.loginThrottle.RecordSuccess(ctx, email)
+	case service.ErrInvalidCredentials:
+		recordErr = c.loginThrottle.RecordFailure(ctx, email, ip)
+	}
+	if recordErr != nil {
+		log.Printf("login throttle update failed: %v", recordErr)
+	}
+}
+
+// clientCredentials reads client authentication from HTTP Basic or, failing
+// that, from the client_id/client_secret form fields
+func clientCredentials(r *http.Request) (clientID, clientSecret string, usedBasic bool) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		return id, secret, true
+	}
+	return r.FormValue("client_id"), r.FormValue("client_secret"), false
+}
+
+// HandleIntrospection reports whether a token is active (RFC 7662). Callers
+// authenticate with their client credentials, via HTTP Basic or the form,
+// and may name the audience they accept tokens for.
+func (c *TokenController) HandleIntrospection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	if err := r.ParseForm(); err != nil {
+		respondWithError(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	clientID, clientSecret, usedBasic := clientCredentials(r)
+	if clientID == "" || clientSecret == "" {
+		respondInvalidClient(w, true)
+		return
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		respondWithError(w, "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+
+	response, err := c.tokenService.IntrospectToken(ctx, clientID, clientSecret, token, r.FormValue("audience"))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidClient:
+			respondInvalidClient(w, usedBasic)
+		case service.ErrInvalidScope:
+			respondWithError(w, "Client may not introspect tokens", http.StatusForbidden)
+		default:
+			log.Printf("token introspection failed: %v", err)
+			respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
 	respondWithJSON(w, response, http.StatusOK)
 }
 
-// HandleTokenValidation handles token validation requests
-// func (c *TokenController) HandleTokenValidation(w http.ResponseWriter, r *http.Request) {
-// 	// Validate request method
-// 	if r.Method != http.MethodGet {
-// 		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
-// 		return
-// 	}
-	
-// 	// Set timeout context
-// 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
-// 	defer cancel()
-	
-// 	// Extract token from Authorization header
-// 	authHeader := r.Header.Get("Authorization")
-// 	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-// 		respondWithError(w, "Authorization header missing or invalid", http.StatusUnauthorized)
-// 		return
-// 	}
-	
-// 	token := strings.TrimPrefix(authHeader, "Bearer ")
-	
-// 	// Validate token
-// 	response, err := c.tokenService.ValidateToken(ctx, token)
-// 	if err != nil {
-// 		switch err {
-// 		case service.ErrTokenNotFound:
-// 			respondWithError(w, "Token not found", http.StatusUnauthorized)
-// 		case service.ErrTokenExpired:
-// 			respondWithError(w, "Token expired", http.StatusUnauthorized)
-// 		default:
-// 			respondWithError(w, "Internal server error", http.StatusInternalServerError)
-// 		}
-// 		return
-// 	}
-	
-// 	// Respond with validation result
-// 	respondWithJSON(w, response, http.StatusOK)
-// }
+// HandleRevocation revokes a refresh or access token (RFC 7009)
+func (c *TokenController) HandleRevocation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	if err := r.ParseForm(); err != nil {
+		respondWithError(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		respondWithError(w, "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.tokenService.RevokeToken(ctx, token, r.FormValue("token_type_hint"), requestInfo(r)); err != nil {
+		log.Printf("token revocation failed: %v", err)
+		respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleJWKS publishes the public keys used to sign access tokens
+func (c *TokenController) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, service.JWKS(), http.StatusOK)
+}
+
+// HandleOpenIDConfiguration serves the OpenID Connect discovery document
+func (c *TokenController) HandleOpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, service.OpenIDConfiguration(), http.StatusOK)
+}
+
+// HandleUserInfo returns the profile of the user the bearer token belongs to
+func (c *TokenController) HandleUserInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="auth-service"`)
+		respondWithError(w, "Authorization header missing or invalid", http.StatusUnauthorized)
+		return
+	}
+
+	response, err := c.tokenService.UserInfo(ctx, token)
+	if err != nil {
+		switch err {
+		case service.ErrInvalidToken, service.ErrTokenExpired, service.ErrTokenRevoked:
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			respondWithError(w, "Invalid token", http.StatusUnauthorized)
+		case service.ErrInvalidScope:
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			respondWithError(w, "Insufficient scope", http.StatusForbidden)
+		default:
+			log.Printf("userinfo failed: %v", err)
+			respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondWithJSON(w, response, http.StatusOK)
+}
+
+// requestInfo records where a request came from for the session list
+func requestInfo(r *http.Request) model.RequestInfo {
+	return model.RequestInfo{
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	return token, token != ""
+}
 
 // Helper functions for HTTP responses
 func respondWithError(w http.ResponseWriter, message string, code int) {
@@ -105,6 +440,37 @@ func respondWithError(w http.ResponseWriter, message string, code int) {
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
+// respondLocked tells a client it has failed to log in too often
+func respondLocked(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondWithOAuthError(w, "invalid_grant", "Too many failed login attempts", http.StatusTooManyRequests)
+}
+
+// respondInvalidClient rejects client authentication. Clients that used
+// HTTP Basic get a challenge as RFC 6749 section 5.2 requires.
+func respondInvalidClient(w http.ResponseWriter, usedBasic bool) {
+	if usedBasic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth-service"`)
+	}
+	respondWithOAuthError(w, "invalid_client", "Invalid client credentials", http.StatusUnauthorized)
+}
+
+// respondWithOAuthError writes an error response of the token endpoint
+// (RFC 6749 section 5.2)
+func respondWithOAuthError(w http.ResponseWriter, code, description string, status int) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	respondWithJSON(w, model.OAuthError{Error: code, ErrorDescription: description}, status)
+}
+
+// respondWithToken writes a successful token response. Tokens must never be
+// cached on the way.
+func respondWithToken(w http.ResponseWriter, response *model.TokenResponse) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	respondWithJSON(w, response, http.StatusOK)
+}
+
 func respondWithJSON(w http.ResponseWriter, data interface{}, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -1,5 +1,7 @@
 module authservice
 
-go 1.22.5
+go 1.23.0
 
 require github.com/lib/pq v1.10.9
+
+require golang.org/x/crypto v0.36.0
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// OutboxSender writes every message to a .eml file in a directory instead of
+// sending it, for local runs and tests without a mail server
+type OutboxSender struct {
+	dir  string
+	from string
+}
+
+func NewOutboxSender(dir, from string) (Sender, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &OutboxSender{dir: dir, from: from}, nil
+}
+
+func (s *OutboxSender) Send(ctx context.Context, msg Message) error {
+	data, err := format(s.from, msg)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(s.dir, fmt.Sprintf("%d-*.eml", time.Now().UnixNano()))
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
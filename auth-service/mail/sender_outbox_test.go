@@ -0,0 +1,73 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutboxSenderWritesMessage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	sender, err := NewOutboxSender(dir, "auth@example.com")
+	if err != nil {
+		t.Fatalf("NewOutboxSender: %v", err)
+	}
+
+	err = sender.Send(context.Background(), Message{
+		To:      "player@example.com",
+		Subject: "Sign in",
+		Body:    "Follow the link\nhttps://example.com/login?token=abc",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("outbox has %v (%v), want one .eml file", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	for _, want := range []string{
+		"From: auth@example.com\r\n",
+		"To: player@example.com\r\n",
+		"Subject: Sign in\r\n",
+		"\r\n\r\nFollow the link\r\nhttps://example.com/login?token=abc",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("message does not contain %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestOutboxSenderRejectsHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{name: "subject with CRLF", msg: Message{To: "player@example.com", Subject: "Hi\r\nBcc: victim@example.com"}},
+		{name: "subject with LF", msg: Message{To: "player@example.com", Subject: "Hi\nBcc: victim@example.com"}},
+		{name: "recipient with CRLF", msg: Message{To: "player@example.com\r\nBcc: victim@example.com", Subject: "Hi"}},
+		{name: "recipient with CR", msg: Message{To: "player@example.com\rBcc: victim@example.com", Subject: "Hi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sender, err := NewOutboxSender(dir, "auth@example.com")
+			if err != nil {
+				t.Fatalf("NewOutboxSender: %v", err)
+			}
+			if err := sender.Send(context.Background(), tt.msg); !errors.Is(err, ErrInvalidHeader) {
+				t.Fatalf("Send() = %v, want ErrInvalidHeader", err)
+			}
+			if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+				t.Errorf("outbox has %v, want nothing written", files)
+			}
+		})
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
 	"authservice/config"
 	"authservice/controller"
+	"authservice/mail"
 	"authservice/middleware"
 	"authservice/repository"
 	"authservice/service"
@@ -19,41 +25,181 @@ func main() {
 
 	cfg := config.New()
 	service.InitSecret(cfg.JWTSecretKey)
+	service.InitIssuer(cfg.Issuer)
+	service.InitTokenPolicies(cfg.TokenPolicy, cfg.ClientTokenPolicies)
+	middleware.TrustForwardedFor(cfg.TrustForwardedFor)
+	if cfg.JWTKeyRingFile != "" {
+		keys, err := service.LoadKeyRingFile(cfg.JWTKeyRingFile)
+		if err != nil {
+			log.Fatalf("Key ring load failed %v", err)
+		}
+		service.InitKeyRing(keys)
+		go service.WatchKeyRingFile(cfg.JWTKeyRingFile, cfg.JWTKeyRingReload)
+	} else if cfg.JWTSigningAlg != service.AlgHS256 {
+		key, err := service.LoadSigningKey(cfg.JWTKeyID, cfg.JWTSigningAlg, cfg.JWTPrivateKeyFile)
+		if err != nil {
+			log.Fatalf("Signing key load failed %v", err)
+		}
+		service.InitSigningKey(key)
+	}
 	db, err := repository.NewDatabase(cfg.DatabaseURL)
 	if err != nil {
 		log.Fatalf("Database connection faile %v", err)
 	}
-	defer db.Close()
 
 	db.SetMaxOpenConns(1000)
 	db.SetMaxIdleConns(100)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
 	tokenRepo := repository.NewPostgresTokenRepository(db)
-	// userRepo := repository.NewPostgresUserRepository(db)
+	clientRepo := repository.NewPostgresClientRepository(db)
+	userRepo := repository.NewPostgresUserRepository(db)
+	codeRepo := repository.NewPostgresAuthorizationCodeRepository(db)
+	service.InitAuditLog(repository.NewPostgresAuditRepository(db))
+	deviceRepo := repository.NewPostgresDeviceCodeRepository(db)
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate-passwords" {
+		migrated, err := service.MigratePlaintextPasswords(context.Background(), userRepo, 500)
+		if err != nil {
+			log.Fatalf("Password migration failed after %d users: %v", migrated, err)
+		}
+		fmt.Printf("Hashed %d plaintext passwords\n", migrated)
+		return
+	}
+
+	tokenService := service.NewTokenService(tokenRepo, clientRepo, userRepo, codeRepo)
+
+	// ctx is cancelled by SIGTERM, which Kubernetes sends before it stops the pod
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	tokenService := service.NewTokenService(tokenRepo)
+	linkRepo := repository.NewPostgresMagicLinkRepository(db)
+	attemptRepo := repository.NewPostgresLoginAttemptRepository(db)
+
+	reaperDone := make(chan struct{})
+	if cfg.ReaperEnabled {
+		reaper := service.NewTokenReaper(
+			tokenRepo,
+			deviceRepo,
+			codeRepo,
+			linkRepo,
+			attemptRepo,
+			repository.NewPostgresLocker(db),
+			cfg.ReaperInterval,
+			cfg.ReaperRetention,
+			cfg.ReaperBatchSize,
+		)
+		go func() {
+			reaper.Run(ctx)
+			close(reaperDone)
+		}()
+	} else {
+		close(reaperDone)
+	}
+
+	loginThrottle := service.NewLoginThrottle(
+		attemptRepo,
+		cfg.LoginMaxFailuresPerEmail,
+		cfg.LoginMaxFailuresPerIP,
+		cfg.LoginLockoutBase,
+		cfg.LoginLockoutMax,
+	)
+
+	var sender mail.Sender
+	if cfg.MailSender == "smtp" {
+		sender = mail.NewSMTPSender(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	} else {
+		sender, err = mail.NewOutboxSender(cfg.MailOutboxDir, cfg.MailFrom)
+		if err != nil {
+			log.Fatalf("Mail outbox setup failed %v", err)
+		}
+	}
+	magicLinkService := service.NewMagicLinkService(
+		tokenService,
+		linkRepo,
+		sender,
+		cfg.MagicLinkURL,
+		cfg.MagicLinkTTL,
+	)
 
-	tokenController := controller.NewTokenController(tokenService)
+	deviceService := service.NewDeviceService(
+		tokenService,
+		deviceRepo,
+		cfg.DeviceVerificationURL,
+		cfg.DeviceCodeTTL,
+	)
+
+	impersonationService := service.NewImpersonationService(
+		tokenService,
+		repository.NewPostgresImpersonationRepository(db),
+		cfg.ImpersonationTTL,
+	)
+
+	tokenController := controller.NewTokenController(tokenService, loginThrottle, magicLinkService, deviceService, impersonationService)
+
+	healthController := controller.NewHealthController(db)
 
 	server := &http.Server{
 		Addr:         ":" + cfg.Port,
-		Handler:      setupRoutes(tokenController),
+		Handler:      setupRoutes(tokenController, healthController, tokenService),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
 
-	fmt.Printf("Server starting on port %s...\n", cfg.Port)
-	log.Fatal(server.ListenAndServe())
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("Server starting on port %s...\n", cfg.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		log.Fatalf("Server failed %v", err)
+	case <-ctx.Done():
+	}
+	// a second signal stops the process right away
+	stop()
 
+	// Fail readiness first and give the Service time to take the pod out of
+	// its endpoints, then let in-flight requests finish
+	log.Printf("Shutting down, draining requests for up to %s", cfg.ShutdownDelay+cfg.ShutdownTimeout)
+	healthController.Drain()
+	time.Sleep(cfg.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown incomplete %v", err)
+	}
+	<-reaperDone
+	if err := db.Close(); err != nil {
+		log.Printf("Database close failed %v", err)
+	}
+	log.Printf("Server stopped")
 }
 
-func setupRoutes(tokenController *controller.TokenController) http.Handler {
+func setupRoutes(tokenController *controller.TokenController, healthController *controller.HealthController, tokenService *service.TokenService) http.Handler {
 	mux := http.NewServeMux()
 
+	// probes are not logged, the kubelet calls them every few seconds
+	mux.Handle("/healthz", middleware.Recover(http.HandlerFunc(healthController.HandleLiveness)))
+	mux.Handle("/readyz", middleware.Recover(http.HandlerFunc(healthController.HandleReadiness)))
+
 	mux.Handle("/token", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleTokenRequest))))
-	// mux.Handle("/refresh", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleTokenValidation))))
+	mux.Handle("/authorize", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleAuthorize))))
+	mux.Handle("/introspect", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleIntrospection))))
+	mux.Handle("/revoke", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleRevocation))))
+	mux.Handle("/.well-known/jwks.json", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleJWKS))))
+	mux.Handle("/.well-known/openid-configuration", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleOpenIDConfiguration))))
+	mux.Handle("/userinfo", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleUserInfo))))
+	mux.Handle("/debug/vars", middleware.Recover(middleware.Logger(middleware.Authenticate(tokenService, middleware.RequireRole(expvar.Handler(), service.RoleAdmin)))))
+	mux.Handle("/magic-link", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleMagicLinkRequest))))
+	mux.Handle("/magic-link/verify", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleMagicLinkVerify))))
+	mux.Handle("/device/code", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleDeviceAuthorization))))
+	mux.Handle("/device", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleDeviceVerification))))
+	mux.Handle("/audit", middleware.Recover(middleware.Logger(middleware.Authenticate(tokenService, middleware.RequirePermission(http.HandlerFunc(tokenController.HandleAuditEvents), service.PermissionAuditRead)))))
+	mux.Handle("/sessions", middleware.Recover(middleware.Logger(http.HandlerFunc(tokenController.HandleSessions))))
 
 	return mux
 }
\ No newline at end of file
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"authservice/model"
+	"authservice/service"
+)
+
+type contextKey string
+
+const claimsKey contextKey = "claims"
+
+// TokenVerifier checks an access token and returns its claims.
+// service.TokenService implements it.
+type TokenVerifier interface {
+	VerifyAccessToken(ctx context.Context, token string) (*model.AccessTokenClaims, error)
+}
+
+// Authenticate rejects requests without a valid bearer token and makes the
+// token's claims available to next through Claims
+func Authenticate(verifier TokenVerifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+		if !strings.HasPrefix(authHeader, "Bearer ") || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="auth-service"`)
+			respondWithError(w, "Authorization header missing or invalid", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := verifier.VerifyAccessToken(r.Context(), token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			respondWithError(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+	})
+}
+
+// Claims returns the claims stored by Authenticate, or nil
+func Claims(ctx context.Context) *model.AccessTokenClaims {
+	claims, _ := ctx.Value(claimsKey).(*model.AccessTokenClaims)
+	return claims
+}
+
+// RequireRole lets a request through only if the user has one of roles.
+// It must be wrapped by Authenticate.
+func RequireRole(next http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := Claims(r.Context())
+		if claims == nil || !slices.Contains(roles, claims.Role) {
+			respondWithError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission lets a request through only if the token grants
+// permission, as a user permission or as a client scope. Impersonated tokens
+// never pass for money permissions. It must be wrapped by Authenticate.
+func RequirePermission(next http.Handler, permission string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := Claims(r.Context())
+		if claims == nil || !service.HasPermission(claims, permission) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			respondWithError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RejectImpersonation turns away tokens a support agent uses on behalf of a
+// user. Endpoints that move money wrap their handler with it. It must be
+// wrapped by Authenticate.
+func RejectImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := Claims(r.Context())
+		if claims == nil || claims.Impersonated() {
+			respondWithError(w, "Not allowed while impersonating", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func respondWithError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
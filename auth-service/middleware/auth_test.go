@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"authservice/model"
+	"authservice/service"
+)
+
+func TestRequirePermission(t *testing.T) {
+	player := model.AccessTokenClaims{Permissions: []string{service.PermissionBalanceWrite, service.PermissionProfileRead}}
+	impersonated := player
+	impersonated.Actor = &model.Actor{Subject: "7", Email: "support@example.com"}
+
+	tests := []struct {
+		name       string
+		claims     *model.AccessTokenClaims
+		permission string
+		want       int
+	}{
+		{name: "granted", claims: &player, permission: service.PermissionBalanceWrite, want: http.StatusOK},
+		{name: "missing", claims: &player, permission: service.PermissionAuditRead, want: http.StatusForbidden},
+		{name: "client scope", claims: &model.AccessTokenClaims{Scope: "payouts:write"}, permission: service.PermissionPayoutsWrite, want: http.StatusOK},
+		{name: "impersonated money", claims: &impersonated, permission: service.PermissionBalanceWrite, want: http.StatusForbidden},
+		{name: "impersonated other", claims: &impersonated, permission: service.PermissionProfileRead, want: http.StatusOK},
+		{name: "no claims", claims: nil, permission: service.PermissionProfileRead, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequirePermission(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), tt.permission)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.claims != nil {
+				r = r.WithContext(context.WithValue(r.Context(), claimsKey, tt.claims))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
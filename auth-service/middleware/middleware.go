@@ -2,11 +2,39 @@ package middleware
 
 import (
 	"log"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
 	"time"
 )
 
+var trustForwardedFor bool
+
+// TrustForwardedFor makes ClientIP use the X-Forwarded-For header. Only
+// enable it when the service is reachable exclusively through a proxy that
+// sets the header, such as the ingress controller.
+func TrustForwardedFor(enabled bool) {
+	trustForwardedFor = enabled
+}
+
+// ClientIP returns the address of the client that made the request
+func ClientIP(r *http.Request) string {
+	if trustForwardedFor {
+		// the proxy appends the address it saw, so the last entry is the
+		// only one a client cannot forge
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -20,7 +48,7 @@ func Logger(next http.Handler) http.Handler {
 		
 		duration := time.Since(start)
 		log.Printf(
-			"method=%s path=%s status=%d duration=%s ip=%s",
+			"method=%s path=%s status=%d duration=%s ip=%s ua=%q",
 			r.Method,
 			r.URL.Path,
 			rw.statusCode,
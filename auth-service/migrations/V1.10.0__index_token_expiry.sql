@@ -0,0 +1,3 @@
+CREATE INDEX idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+CREATE INDEX idx_refresh_tokens_revoked_at ON refresh_tokens(revoked_at) WHERE revoked_at IS NOT NULL;
+CREATE INDEX idx_revoked_access_tokens_expires_at ON revoked_access_tokens(expires_at);
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN role VARCHAR(32) NOT NULL DEFAULT 'player';
+
+ALTER TABLE users ADD CONSTRAINT users_role_check
+    CHECK (role IN ('player', 'support', 'finance', 'admin'));
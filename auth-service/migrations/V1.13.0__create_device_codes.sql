@@ -0,0 +1,16 @@
+CREATE TABLE device_codes (
+    device_code_hash TEXT PRIMARY KEY,
+    user_code VARCHAR(16) NOT NULL UNIQUE,
+    client_id VARCHAR(255) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    scope TEXT NOT NULL DEFAULT '',
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'denied', 'consumed')),
+    poll_interval INT NOT NULL,
+    last_polled_at TIMESTAMP WITH TIME ZONE,
+    family_id TEXT,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX idx_device_codes_expires_at ON device_codes(expires_at);
@@ -0,0 +1,17 @@
+CREATE TABLE impersonation_audit (
+    id BIGSERIAL PRIMARY KEY,
+    actor_user_id INT NOT NULL REFERENCES users(id),
+    target_user_id INT REFERENCES users(id),
+    requested_subject TEXT NOT NULL,
+    client_id VARCHAR(255),
+    token_id TEXT,
+    reason TEXT NOT NULL DEFAULT '',
+    outcome VARCHAR(16) NOT NULL CHECK (outcome IN ('granted', 'denied')),
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_impersonation_audit_actor ON impersonation_audit(actor_user_id, created_at);
+CREATE INDEX idx_impersonation_audit_target ON impersonation_audit(target_user_id, created_at);
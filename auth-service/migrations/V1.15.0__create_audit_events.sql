@@ -0,0 +1,28 @@
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(64) NOT NULL,
+    outcome VARCHAR(16) NOT NULL CHECK (outcome IN ('success', 'failure')),
+    -- no foreign key: entries must outlive the users they are about
+    user_id INT,
+    email TEXT NOT NULL DEFAULT '',
+    actor TEXT NOT NULL DEFAULT '',
+    client_id VARCHAR(255) NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id, created_at);
+CREATE INDEX idx_audit_events_email ON audit_events(email, created_at);
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at);
+
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,3 @@
+ALTER TABLE authorization_codes RENAME COLUMN code TO code_hash;
+
+UPDATE authorization_codes SET code_hash = encode(sha256(convert_to(code_hash, 'UTF8')), 'hex');
@@ -0,0 +1,12 @@
+-- Refresh tokens are stored as SHA-256 hex like the other secrets.
+-- Families from before rotation took the first token as their id, which is
+-- shown as the session id: those ids are hashed as well. Access tokens of
+-- such sessions stop being accepted and have to be refreshed.
+UPDATE refresh_tokens SET family_id = encode(sha256(convert_to(family_id, 'UTF8')), 'hex')
+WHERE family_id IN (SELECT refresh_token FROM refresh_tokens);
+
+ALTER TABLE refresh_tokens RENAME COLUMN refresh_token TO token_hash;
+
+UPDATE refresh_tokens
+SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex'),
+    replaced_by = encode(sha256(convert_to(replaced_by, 'UTF8')), 'hex');
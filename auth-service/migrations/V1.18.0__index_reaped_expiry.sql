@@ -0,0 +1,3 @@
+CREATE INDEX idx_authorization_codes_expires_at ON authorization_codes(expires_at);
+CREATE INDEX idx_magic_links_expires_at ON magic_links(expires_at);
+CREATE INDEX idx_login_attempts_last_failure_at ON login_attempts(last_failure_at);
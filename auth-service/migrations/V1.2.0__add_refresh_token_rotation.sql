@@ -0,0 +1,10 @@
+ALTER TABLE refresh_tokens
+    ADD COLUMN family_id TEXT,
+    ADD COLUMN replaced_by TEXT,
+    ADD COLUMN revoked_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE refresh_tokens SET family_id = refresh_token WHERE family_id IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
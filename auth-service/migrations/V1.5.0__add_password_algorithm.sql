@@ -0,0 +1,3 @@
+-- Rows that predate hashing keep their plaintext password until the owner
+-- logs in again or "authservice migrate-passwords" is run.
+ALTER TABLE users ADD COLUMN password_algo VARCHAR(32) NOT NULL DEFAULT 'plain';
@@ -0,0 +1,19 @@
+ALTER TABLE refresh_tokens ADD COLUMN scope TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE oauth_clients
+    ADD COLUMN redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN public BOOLEAN NOT NULL DEFAULT false,
+    ALTER COLUMN client_secret_hash DROP NOT NULL;
+
+CREATE TABLE authorization_codes (
+    code TEXT PRIMARY KEY,
+    client_id VARCHAR(255) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    code_challenge TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    family_id TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
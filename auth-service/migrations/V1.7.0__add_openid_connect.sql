@@ -0,0 +1,3 @@
+ALTER TABLE authorization_codes ADD COLUMN nonce TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE refresh_tokens ADD COLUMN client_id VARCHAR(255);
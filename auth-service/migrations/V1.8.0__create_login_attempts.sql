@@ -0,0 +1,7 @@
+-- attempt_key is "email:<address>" or "ip:<address>"
+CREATE TABLE login_attempts (
+    attempt_key TEXT PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
@@ -0,0 +1,5 @@
+ALTER TABLE refresh_tokens
+    ADD COLUMN ip TEXT,
+    ADD COLUMN user_agent TEXT;
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
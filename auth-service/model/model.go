@@ -1,11 +1,27 @@
 package model
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type User struct {
 	Username 	 string   `json:"username"`
 	Email        string   `json:"email"`  
 	ClientSecret string   `json:"client_secret"`
 }
 
+// UserCredentials is what is needed to check a user's password.
+// PasswordHash holds the plaintext password while PasswordAlgo is "plain".
+type UserCredentials struct {
+	ID           int
+	Username     string
+	Email        string
+	PasswordHash string
+	PasswordAlgo string
+	Role         string
+}
+
 // type Token struct {
 // 	AccessToken    string    `json:"access_token"`
 // 	RefreshToken       string    `json:"refresh_token"`
@@ -13,12 +29,314 @@ type User struct {
 
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+	IDToken     string `json:"id_token,omitempty"`
+	// IssuedTokenType is only set by the token exchange grant (RFC 8693)
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// Client is a registered OAuth client. Public clients (browser and mobile
+// apps) have no secret and must use PKCE.
+type Client struct {
+	ClientID     string
+	SecretHash   string
+	Scopes       []string
+	RedirectURIs []string
+	Public       bool
+}
+
+// RefreshToken is a row of refresh_tokens. Only the SHA-256 of the token is
+// stored. Email is filled in on lookup.
+type RefreshToken struct {
+	TokenHash string
+	UserID    int
+	Email     string
+	Role      string
+	FamilyID  string
+	Scope     string
+	ClientID  string
+	IP        string
+	UserAgent string
+	ExpiresAt time.Time
+}
+
+// OAuthError is the error body of the token endpoint (RFC 6749 section 5.2)
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// RequestInfo describes where a token request came from
+type RequestInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// Session is one login: a refresh token family described by its newest token.
+// ID is the family ID.
+type Session struct {
+	ID         string    `json:"id"`
+	ClientID   string    `json:"client_id,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current,omitempty"`
+}
+
+// AuthorizationRequest holds the parameters of an /authorize call
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// AuthorizationCode is a single-use code issued by /authorize
+type AuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        int
+	Email         string
+	Role          string
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	Nonce         string
+	ExpiresAt     time.Time
+}
+
+// Statuses of a device authorization
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+	DeviceCodeConsumed = "consumed"
+)
+
+// DeviceCode is a device authorization (RFC 8628). Only a hash of the device
+// code is stored; the user code is what the user types in on another device.
+// Email and Role are filled in once a user has approved it.
+type DeviceCode struct {
+	DeviceCodeHash string
+	UserCode       string
+	ClientID       string
+	Scope          string
+	UserID         int
+	Email          string
+	Role           string
+	Status         string
+	Interval       time.Duration
+	ExpiresAt      time.Time
+}
+
+// DeviceAuthorizationResponse follows RFC 8628 section 3.2
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// MagicLink is a one-time login link. Only a hash of the token in the link
+// is stored.
+type MagicLink struct {
+	TokenHash string
+	UserID    int
+	Email     string
+	Role      string
+	ClientID  string
+	Scope     string
+	ExpiresAt time.Time
+}
+
+// AccessTokenClaims is the payload of access tokens issued by TokenService
+type AccessTokenClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	Email     string   `json:"email,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	ID        string   `json:"jti"`
+	SessionID string   `json:"sid,omitempty"`
+	// Role and Permissions are only set on tokens issued to users
+	Role        string   `json:"role,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// Actor is set when a support agent acts as the user (RFC 8693)
+	Actor *Actor `json:"act,omitempty"`
+}
+
+// Impersonated reports whether someone other than the subject is using the token
+func (c *AccessTokenClaims) Impersonated() bool {
+	return c.Actor != nil
 }
 
-// type TokenValidationResponse struct {
-// 	ClientID string   `json:"client_id"`
-// 	Scope    []string `json:"scope"`
-// 	Valid    bool     `json:"valid"`
-// }
\ No newline at end of file
+// Actor identifies who acts on behalf of the subject of a token
+// (RFC 8693 section 4.1)
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// AuditEvent is an entry of the security audit log. UserID and Email name
+// the user the event is about, Actor who caused it: a user's email,
+// "client:<client_id>" or "system".
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	Outcome   string    `json:"outcome"`
+	UserID    int       `json:"user_id,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditFilter selects audit events, newest first. Zero fields do not filter;
+// BeforeID continues a previous page.
+type AuditFilter struct {
+	UserID   int
+	Email    string
+	Type     string
+	From     time.Time
+	To       time.Time
+	BeforeID int64
+	Limit    int
+}
+
+// ImpersonationEvent is a row of impersonation_audit. TargetUserID is zero
+// when the requested subject was not found.
+type ImpersonationEvent struct {
+	ActorUserID      int
+	TargetUserID     int
+	RequestedSubject string
+	ClientID         string
+	TokenID          string
+	Reason           string
+	Outcome          string
+	IP               string
+	UserAgent        string
+	ExpiresAt        time.Time
+}
+
+// Audience is the aud claim. RFC 7519 allows a single string or an array;
+// a single audience is written as a string.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// TokenPolicy controls the tokens issued to a client
+type TokenPolicy struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// Audience lists the services the access tokens are meant for
+	Audience []string
+}
+
+// IDTokenClaims is the payload of OpenID Connect ID tokens
+type IDTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	Nonce     string `json:"nonce,omitempty"`
+	Email     string `json:"email,omitempty"`
+}
+
+// UserInfo is returned by the OpenID Connect userinfo endpoint
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Username          string `json:"username"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+}
+
+// OpenIDConfiguration is the OpenID Connect discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// IntrospectionResponse follows RFC 7662 section 2.2
+type IntrospectionResponse struct {
+	Active      bool     `json:"active"`
+	Scope       string   `json:"scope,omitempty"`
+	ClientID    string   `json:"client_id,omitempty"`
+	Subject     string   `json:"sub,omitempty"`
+	Audience    Audience `json:"aud,omitempty"`
+	Issuer      string   `json:"iss,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Actor       *Actor   `json:"act,omitempty"`
+	TokenType   string   `json:"token_type,omitempty"`
+	ExpiresAt   int64    `json:"exp,omitempty"`
+	IssuedAt    int64    `json:"iat,omitempty"`
+}
+
+
+// JWK is a public key in the RFC 7517 format
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
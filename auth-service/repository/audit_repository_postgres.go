@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"authservice/model"
+)
+
+type PostgresAuditRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresAuditRepository(db *sql.DB) AuditRepository {
+	return &PostgresAuditRepository{db: db}
+}
+
+func (r *PostgresAuditRepository) AppendAuditEvent(ctx context.Context, event *model.AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (event_type, outcome, user_id, email, actor, client_id, ip, user_agent, detail)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, $7, $8, $9)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		event.Type,
+		event.Outcome,
+		event.UserID,
+		event.Email,
+		event.Actor,
+		event.ClientID,
+		event.IP,
+		event.UserAgent,
+		event.Detail,
+	)
+	return err
+}
+
+func (r *PostgresAuditRepository) ListAuditEvents(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if filter.UserID != 0 {
+		where("user_id = ?", filter.UserID)
+	}
+	if filter.Email != "" {
+		where("email = ?", filter.Email)
+	}
+	if filter.Type != "" {
+		where("event_type = ?", filter.Type)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("created_at < ?", filter.To)
+	}
+	if filter.BeforeID != 0 {
+		where("id < ?", filter.BeforeID)
+	}
+
+	query := `
+		SELECT id, event_type, outcome, COALESCE(user_id, 0), email, actor, client_id, ip, user_agent, detail, created_at
+		FROM audit_events
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []model.AuditEvent{}
+	for rows.Next() {
+		var event model.AuditEvent
+		if err := rows.Scan(
+			&event.ID,
+			&event.Type,
+			&event.Outcome,
+			&event.UserID,
+			&event.Email,
+			&event.Actor,
+			&event.ClientID,
+			&event.IP,
+			&event.UserAgent,
+			&event.Detail,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"authservice/model"
+	"github.com/lib/pq"
+)
+
+type PostgresClientRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresClientRepository(db *sql.DB) ClientRepository {
+	return &PostgresClientRepository{db: db}
+}
+
+func (r *PostgresClientRepository) GetClient(ctx context.Context, clientID string) (*model.Client, error) {
+	query := `
+		SELECT client_id, COALESCE(client_secret_hash, ''), scopes, redirect_uris, public
+		FROM oauth_clients
+		WHERE client_id = $1
+	`
+	var client model.Client
+	err := r.db.QueryRowContext(ctx, query, clientID).Scan(
+		&client.ClientID,
+		&client.SecretHash,
+		pq.Array(&client.Scopes),
+		pq.Array(&client.RedirectURIs),
+		&client.Public,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
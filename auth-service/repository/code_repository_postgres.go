@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"authservice/model"
+)
+
+type PostgresAuthorizationCodeRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresAuthorizationCodeRepository(db *sql.DB) AuthorizationCodeRepository {
+	return &PostgresAuthorizationCodeRepository{db: db}
+}
+
+func (r *PostgresAuthorizationCodeRepository) CreateAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) error {
+	query := `
+		INSERT INTO authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, nonce, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := r.db.ExecContext(ctx, query, code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.CodeChallenge, code.Nonce, code.ExpiresAt)
+	return err
+}
+
+func (r *PostgresAuthorizationCodeRepository) ConsumeAuthorizationCode(ctx context.Context, codeHash string, issue func(*model.AuthorizationCode) (*model.RefreshToken, error)) (*model.AuthorizationCode, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		result         model.AuthorizationCode
+		usedAt         sql.NullTime
+		issuedFamilyID sql.NullString
+	)
+	query := `
+		SELECT ac.code_hash, ac.client_id, ac.user_id, u.email, u.role, ac.redirect_uri, ac.scope, ac.code_challenge, ac.nonce, ac.expires_at, ac.used_at, ac.family_id
+		FROM authorization_codes ac
+		JOIN users u ON u.id = ac.user_id
+		WHERE ac.code_hash = $1
+		FOR UPDATE OF ac
+	`
+	err = tx.QueryRowContext(ctx, query, codeHash).Scan(
+		&result.CodeHash,
+		&result.ClientID,
+		&result.UserID,
+		&result.Email,
+		&result.Role,
+		&result.RedirectURI,
+		&result.Scope,
+		&result.CodeChallenge,
+		&result.Nonce,
+		&result.ExpiresAt,
+		&usedAt,
+		&issuedFamilyID,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrAuthorizationCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if usedAt.Valid {
+		// RFC 6749 section 4.1.2: tokens issued for a code that is
+		// presented twice should be revoked
+		if issuedFamilyID.Valid {
+			if err := revokeFamily(ctx, tx, issuedFamilyID.String); err != nil {
+				return nil, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrAuthorizationCodeReused
+	}
+
+	refreshToken, err := issue(&result)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertRefreshToken(ctx, tx, refreshToken); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE authorization_codes
+		SET used_at = now(), family_id = $2
+		WHERE code_hash = $1
+	`, codeHash, refreshToken.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *PostgresAuthorizationCodeRepository) DeleteExpiredAuthorizationCodes(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	query := `
+		DELETE FROM authorization_codes
+		WHERE code_hash IN (
+			SELECT code_hash FROM authorization_codes WHERE expires_at < $1 LIMIT $2
+		)
+	`
+	return execCount(ctx, r.db, query, cutoff, limit)
+}
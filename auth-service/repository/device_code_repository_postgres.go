@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"authservice/model"
+
+	"github.com/lib/pq"
+)
+
+type PostgresDeviceCodeRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresDeviceCodeRepository(db *sql.DB) DeviceCodeRepository {
+	return &PostgresDeviceCodeRepository{db: db}
+}
+
+func (r *PostgresDeviceCodeRepository) CreateDeviceCode(ctx context.Context, code *model.DeviceCode) error {
+	query := `
+		INSERT INTO device_codes (device_code_hash, user_code, client_id, scope, poll_interval, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := r.db.ExecContext(ctx, query, code.DeviceCodeHash, code.UserCode, code.ClientID, code.Scope, int(code.Interval.Seconds()), code.ExpiresAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "device_codes_user_code_key" {
+		return ErrUserCodeTaken
+	}
+	return err
+}
+
+func (r *PostgresDeviceCodeRepository) GetPendingDeviceCode(ctx context.Context, userCode string) (*model.DeviceCode, error) {
+	query := `
+		SELECT device_code_hash, user_code, client_id, scope, status, poll_interval, expires_at
+		FROM device_codes
+		WHERE user_code = $1 AND status = 'pending' AND expires_at > now()
+	`
+	var (
+		code     model.DeviceCode
+		interval int
+	)
+	err := r.db.QueryRowContext(ctx, query, userCode).Scan(
+		&code.DeviceCodeHash,
+		&code.UserCode,
+		&code.ClientID,
+		&code.Scope,
+		&code.Status,
+		&interval,
+		&code.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrDeviceCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	code.Interval = time.Duration(interval) * time.Second
+	return &code, nil
+}
+
+func (r *PostgresDeviceCodeRepository) ResolveDeviceCode(ctx context.Context, userCode string, userID int, approved bool) error {
+	status := model.DeviceCodeDenied
+	if approved {
+		status = model.DeviceCodeApproved
+	}
+	query := `
+		UPDATE device_codes
+		SET status = $3, user_id = $2
+		WHERE user_code = $1 AND status = 'pending' AND expires_at > now()
+	`
+	result, err := r.db.ExecContext(ctx, query, userCode, userID, status)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrDeviceCodeNotFound
+	}
+	return nil
+}
+
+func (r *PostgresDeviceCodeRepository) PollDeviceCode(ctx context.Context, deviceCodeHash, clientID, familyID string) (*model.DeviceCode, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		code     model.DeviceCode
+		userID   sql.NullInt64
+		email    sql.NullString
+		role     sql.NullString
+		interval int
+		tooSoon  bool
+	)
+	// the clock of the database decides about slow_down so that replicas
+	// of auth-service with skewed clocks agree
+	query := `
+		SELECT dc.device_code_hash, dc.user_code, dc.client_id, dc.scope, dc.user_id, u.email, u.role,
+		       dc.status, dc.poll_interval, dc.expires_at,
+		       COALESCE(dc.last_polled_at > now() - make_interval(secs => dc.poll_interval), false)
+		FROM device_codes dc
+		LEFT JOIN users u ON u.id = dc.user_id
+		WHERE dc.device_code_hash = $1 AND dc.client_id = $2
+		FOR UPDATE OF dc
+	`
+	err = tx.QueryRowContext(ctx, query, deviceCodeHash, clientID).Scan(
+		&code.DeviceCodeHash,
+		&code.UserCode,
+		&code.ClientID,
+		&code.Scope,
+		&userID,
+		&email,
+		&role,
+		&code.Status,
+		&interval,
+		&code.ExpiresAt,
+		&tooSoon,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrDeviceCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	code.UserID = int(userID.Int64)
+	code.Email = email.String
+	code.Role = role.String
+	code.Interval = time.Duration(interval) * time.Second
+
+	if tooSoon {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE device_codes
+			SET poll_interval = poll_interval + 5, last_polled_at = now()
+			WHERE device_code_hash = $1
+		`, deviceCodeHash)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrDeviceCodeSlowDown
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE device_codes
+		SET last_polled_at = now(),
+		    status = CASE WHEN status = 'approved' AND expires_at > now() THEN 'consumed' ELSE status END,
+		    family_id = CASE WHEN status = 'approved' AND expires_at > now() THEN $2 ELSE family_id END
+		WHERE device_code_hash = $1
+	`, deviceCodeHash, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (r *PostgresDeviceCodeRepository) DeleteExpiredDeviceCodes(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	query := `
+		DELETE FROM device_codes
+		WHERE device_code_hash IN (
+			SELECT device_code_hash FROM device_codes WHERE expires_at < $1 LIMIT $2
+		)
+	`
+	return execCount(ctx, r.db, query, cutoff, limit)
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"authservice/model"
+)
+
+type PostgresImpersonationRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresImpersonationRepository(db *sql.DB) ImpersonationRepository {
+	return &PostgresImpersonationRepository{db: db}
+}
+
+func (r *PostgresImpersonationRepository) RecordImpersonation(ctx context.Context, event *model.ImpersonationEvent) error {
+	query := `
+		INSERT INTO impersonation_audit
+			(actor_user_id, target_user_id, requested_subject, client_id, token_id, reason, outcome, ip, user_agent, expires_at)
+		VALUES ($1, NULLIF($2, 0), $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9, $10)
+	`
+	var expiresAt sql.NullTime
+	if !event.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: event.ExpiresAt, Valid: true}
+	}
+	_, err := r.db.ExecContext(ctx, query,
+		event.ActorUserID,
+		event.TargetUserID,
+		event.RequestedSubject,
+		event.ClientID,
+		event.TokenID,
+		event.Reason,
+		event.Outcome,
+		event.IP,
+		event.UserAgent,
+		expiresAt,
+	)
+	return err
+}
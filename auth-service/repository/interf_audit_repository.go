@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+
+	"authservice/model"
+)
+
+type AuditRepository interface {
+	// AppendAuditEvent adds an entry to the audit log. Entries are never
+	// changed or deleted afterwards.
+	AppendAuditEvent(ctx context.Context, event *model.AuditEvent) error
+	ListAuditEvents(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error)
+}
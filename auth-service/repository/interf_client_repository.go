@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"authservice/model"
+)
+
+var ErrClientNotFound = errors.New("client not found")
+
+type ClientRepository interface {
+	GetClient(ctx context.Context, clientID string) (*model.Client, error)
+}
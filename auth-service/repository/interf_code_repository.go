@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"authservice/model"
+)
+
+var (
+	ErrAuthorizationCodeNotFound = errors.New("authorization code not found")
+	ErrAuthorizationCodeReused   = errors.New("authorization code has already been used")
+)
+
+type AuthorizationCodeRepository interface {
+	CreateAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) error
+	// ConsumeAuthorizationCode runs issue on the code while holding its row
+	// lock. issue checks the code and returns the refresh token to store for
+	// it; the token is stored and the code marked used with the token's
+	// family in one transaction. When issue fails the code is left unused and
+	// its error is returned. A second redemption revokes that family and
+	// returns ErrAuthorizationCodeReused.
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string, issue func(*model.AuthorizationCode) (*model.RefreshToken, error)) (*model.AuthorizationCode, error)
+	// DeleteExpiredAuthorizationCodes deletes up to limit codes that expired
+	// before cutoff and returns how many were deleted
+	DeleteExpiredAuthorizationCodes(ctx context.Context, cutoff time.Time, limit int) (int, error)
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"authservice/model"
+)
+
+var (
+	ErrDeviceCodeNotFound = errors.New("device code not found")
+	ErrUserCodeTaken      = errors.New("user code is already in use")
+	ErrDeviceCodeSlowDown = errors.New("device code polled before its interval passed")
+)
+
+type DeviceCodeRepository interface {
+	// CreateDeviceCode stores a new pending code. ErrUserCodeTaken means the
+	// user code collided with an existing one and a new one should be drawn.
+	CreateDeviceCode(ctx context.Context, code *model.DeviceCode) error
+	// GetPendingDeviceCode finds a code by user code that is still waiting
+	// for the user and has not expired
+	GetPendingDeviceCode(ctx context.Context, userCode string) (*model.DeviceCode, error)
+	// ResolveDeviceCode approves or denies a pending code on behalf of the
+	// user. Codes that are not pending or have expired give ErrDeviceCodeNotFound.
+	ResolveDeviceCode(ctx context.Context, userCode string, userID int, approved bool) error
+	// PollDeviceCode records a poll by the device and returns the code as it
+	// was. An approved code is marked consumed together with the refresh
+	// token family issued for it, so it yields tokens once. Polling sooner
+	// than the interval raises the interval by five seconds and gives
+	// ErrDeviceCodeSlowDown.
+	PollDeviceCode(ctx context.Context, deviceCodeHash, clientID, familyID string) (*model.DeviceCode, error)
+	// DeleteExpiredDeviceCodes deletes up to limit codes that expired
+	// before cutoff and returns how many were deleted
+	DeleteExpiredDeviceCodes(ctx context.Context, cutoff time.Time, limit int) (int, error)
+}
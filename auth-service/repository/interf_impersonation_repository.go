@@ -0,0 +1,12 @@
+package repository
+
+import (
+	"context"
+
+	"authservice/model"
+)
+
+type ImpersonationRepository interface {
+	// RecordImpersonation appends an entry to the impersonation audit trail
+	RecordImpersonation(ctx context.Context, event *model.ImpersonationEvent) error
+}
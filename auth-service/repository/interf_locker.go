@@ -0,0 +1,10 @@
+package repository
+
+import "context"
+
+// Locker elects a single replica to run a job
+type Locker interface {
+	// TryLock takes the lock named by key without waiting. When acquired is
+	// true the caller holds the lock until it calls unlock.
+	TryLock(ctx context.Context, key int64) (unlock func(), acquired bool, err error)
+}
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+type LoginAttemptRepository interface {
+	// LockedUntil returns the latest lockout still in force for any of the
+	// keys, or the zero time when none is locked
+	LockedUntil(ctx context.Context, keys []string) (time.Time, error)
+	// RecordFailure counts a failed attempt and returns the number of
+	// failures for key. Failures older than window are forgotten.
+	RecordFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	// DeleteStaleLoginAttempts deletes up to limit keys whose last failure
+	// and lockout both ended before cutoff and returns how many were deleted
+	DeleteStaleLoginAttempts(ctx context.Context, cutoff time.Time, limit int) (int, error)
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"authservice/model"
+)
+
+var ErrMagicLinkNotFound = errors.New("magic link not found")
+
+type MagicLinkRepository interface {
+	CreateMagicLink(ctx context.Context, link *model.MagicLink) error
+	// CountMagicLinks returns how many links were created for the user since
+	CountMagicLinks(ctx context.Context, userID int, since time.Time) (int, error)
+	// ConsumeMagicLink marks the link used and returns it. Links that are
+	// unknown, expired or already used all give ErrMagicLinkNotFound.
+	ConsumeMagicLink(ctx context.Context, tokenHash string) (*model.MagicLink, error)
+	// DeleteExpiredMagicLinks deletes up to limit links that expired before
+	// cutoff and returns how many were deleted
+	DeleteExpiredMagicLinks(ctx context.Context, cutoff time.Time, limit int) (int, error)
+}
@@ -2,11 +2,48 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
+
+	"authservice/model"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenExpired  = errors.New("refresh token has expired")
+	ErrRefreshTokenRevoked  = errors.New("refresh token has been revoked")
+	ErrRefreshTokenReused   = errors.New("refresh token has already been rotated")
 )
 
 type TokenRepository interface {
-// 	ValidateCredentials(ctx context.Context, clientID, clientSecret, requestedScope string) (bool, error)
-// 	GetUserScopes(ctx context.Context, clientID string) ([]string, error)
-	CreateToken(ctx context.Context, email, clientSecret, refreshToken string, expiresAt time.Time) error
-}
\ No newline at end of file
+	CreateToken(ctx context.Context, token *model.RefreshToken) error
+	// RotateRefreshToken swaps the token oldHash for newHash within the same
+	// family and returns the new token, which expires at expiresAt(client of
+	// the family). Presenting a token that was already rotated revokes the
+	// whole family and returns ErrRefreshTokenReused. check runs on the live
+	// token before it is rotated; its error is returned and leaves the token
+	// untouched.
+	RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt func(clientID string) time.Time, check func(*model.RefreshToken) error, info model.RequestInfo) (*model.RefreshToken, error)
+	// RevokeRefreshToken revokes the family the token tokenHash belongs to
+	// and returns the token with its user. Unknown tokens give
+	// ErrRefreshTokenNotFound.
+	RevokeRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error)
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsAccessTokenRevoked reports whether the token was revoked by jti or,
+	// when familyID is set, whether its refresh token family was revoked
+	IsAccessTokenRevoked(ctx context.Context, jti, familyID string) (bool, error)
+	// ListSessions returns the live refresh token families of a user, newest
+	// activity first
+	ListSessions(ctx context.Context, email string) ([]model.Session, error)
+	// RevokeSessions revokes the user's family familyID, or every family of
+	// the user when familyID is empty, and returns how many were revoked
+	RevokeSessions(ctx context.Context, email, familyID string) (int, error)
+	// DeleteStaleRefreshTokens deletes up to limit refresh tokens of families
+	// whose every token expired or was revoked before cutoff. Tokens of any
+	// other family are kept, rotated and expired ones included, so reuse of
+	// them is still detected. It returns the number of rows deleted.
+	DeleteStaleRefreshTokens(ctx context.Context, cutoff time.Time, limit int) (int, error)
+	// DeleteExpiredRevocations deletes up to limit denylist entries of access
+	// tokens that expired before cutoff
+	DeleteExpiredRevocations(ctx context.Context, cutoff time.Time, limit int) (int, error)
+}
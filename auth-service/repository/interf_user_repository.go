@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"authservice/model"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+type UserRepository interface {
+	GetCredentialsByEmail(ctx context.Context, email string) (*model.UserCredentials, error)
+	// UpdatePassword replaces the password only while it is still stored with
+	// currentAlgorithm and reports whether it did, so a password changed in
+	// the meantime is not overwritten
+	UpdatePassword(ctx context.Context, userID int, currentAlgorithm, passwordHash, algorithm string) (bool, error)
+	// ListUsersByPasswordAlgorithm returns up to limit users whose password is
+	// stored with algorithm and whose id is greater than afterID
+	ListUsersByPasswordAlgorithm(ctx context.Context, algorithm string, afterID, limit int) ([]model.UserCredentials, error)
+}
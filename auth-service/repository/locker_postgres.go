@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log"
+)
+
+// PostgresLocker uses session level advisory locks. The lock lives on its own
+// connection, so it is released by Postgres if the replica dies mid-job.
+type PostgresLocker struct {
+	db *sql.DB
+}
+
+func NewPostgresLocker(db *sql.DB) Locker {
+	return &PostgresLocker{db: db}
+}
+
+func (l *PostgresLocker) TryLock(ctx context.Context, key int64) (func(), bool, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	unlock := func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			log.Printf("advisory unlock %d failed: %v", key, err)
+			// drop the connection instead of handing it back to the pool
+			// with the lock still held
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}
+	return unlock, true, nil
+}
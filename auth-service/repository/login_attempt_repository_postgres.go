@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type PostgresLoginAttemptRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresLoginAttemptRepository(db *sql.DB) LoginAttemptRepository {
+	return &PostgresLoginAttemptRepository{db: db}
+}
+
+func (r *PostgresLoginAttemptRepository) LockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	query := `
+		SELECT MAX(locked_until)
+		FROM login_attempts
+		WHERE attempt_key = ANY($1) AND locked_until > now()
+	`
+	var lockedUntil sql.NullTime
+	if err := r.db.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&lockedUntil); err != nil {
+		return time.Time{}, err
+	}
+	return lockedUntil.Time, nil
+}
+
+func (r *PostgresLoginAttemptRepository) RecordFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_attempts (attempt_key, failures, last_failure_at)
+		VALUES ($1, 1, now())
+		ON CONFLICT (attempt_key) DO UPDATE
+		SET failures = CASE
+				WHEN login_attempts.last_failure_at < now() - make_interval(secs => $2) THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = now()
+		RETURNING failures
+	`
+	var failures int
+	if err := r.db.QueryRowContext(ctx, query, key, window.Seconds()).Scan(&failures); err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+func (r *PostgresLoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `UPDATE login_attempts SET locked_until = $2 WHERE attempt_key = $1`
+	_, err := r.db.ExecContext(ctx, query, key, until)
+	return err
+}
+
+func (r *PostgresLoginAttemptRepository) Reset(ctx context.Context, key string) error {
+	query := `DELETE FROM login_attempts WHERE attempt_key = $1`
+	_, err := r.db.ExecContext(ctx, query, key)
+	return err
+}
+
+func (r *PostgresLoginAttemptRepository) DeleteStaleLoginAttempts(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	query := `
+		DELETE FROM login_attempts
+		WHERE attempt_key IN (
+			SELECT attempt_key FROM login_attempts
+			WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $1)
+			LIMIT $2
+		)
+	`
+	return execCount(ctx, r.db, query, cutoff, limit)
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"authservice/model"
+)
+
+type PostgresMagicLinkRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresMagicLinkRepository(db *sql.DB) MagicLinkRepository {
+	return &PostgresMagicLinkRepository{db: db}
+}
+
+func (r *PostgresMagicLinkRepository) CreateMagicLink(ctx context.Context, link *model.MagicLink) error {
+	query := `
+		INSERT INTO magic_links (token_hash, user_id, client_id, scope, expires_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+	`
+	_, err := r.db.ExecContext(ctx, query, link.TokenHash, link.UserID, link.ClientID, link.Scope, link.ExpiresAt)
+	return err
+}
+
+func (r *PostgresMagicLinkRepository) CountMagicLinks(ctx context.Context, userID int, since time.Time) (int, error) {
+	query := `SELECT COUNT(*) FROM magic_links WHERE user_id = $1 AND created_at > $2`
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, userID, since).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *PostgresMagicLinkRepository) ConsumeMagicLink(ctx context.Context, tokenHash string) (*model.MagicLink, error) {
+	// the conditional update makes a link usable exactly once even when it
+	// is followed twice at the same time
+	query := `
+		WITH used AS (
+			UPDATE magic_links
+			SET used_at = now()
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+			RETURNING token_hash, user_id, COALESCE(client_id, '') AS client_id, scope, expires_at
+		)
+		SELECT used.token_hash, used.user_id, u.email, u.role, used.client_id, used.scope, used.expires_at
+		FROM used
+		JOIN users u ON u.id = used.user_id
+	`
+	var link model.MagicLink
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&link.TokenHash,
+		&link.UserID,
+		&link.Email,
+		&link.Role,
+		&link.ClientID,
+		&link.Scope,
+		&link.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrMagicLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (r *PostgresMagicLinkRepository) DeleteExpiredMagicLinks(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	query := `
+		DELETE FROM magic_links
+		WHERE token_hash IN (
+			SELECT token_hash FROM magic_links WHERE expires_at < $1 LIMIT $2
+		)
+	`
+	return execCount(ctx, r.db, query, cutoff, limit)
+}
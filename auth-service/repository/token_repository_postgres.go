@@ -3,8 +3,9 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"time"
+
+	"authservice/model"
 )
 
 type PostgresTokenRepository struct {
@@ -15,121 +16,265 @@ func NewPostgresTokenRepository(db *sql.DB) TokenRepository {
 	return &PostgresTokenRepository{db: db}
 }
 
-func (r *PostgresTokenRepository) CreateToken(ctx context.Context, email, clientSecret, refreshToken string, expiresAt time.Time) error {
-	// header := map[string]string{
-	// 	"alg": "HS256",
-	// 	"typ": "JWT",
-	// }
-	// headerJson, _ := json.Marshal(header)
-	// headerEncoded := base64.RawURLEncoding.EncodeToString(headerJson)
-
-	// expirationTime := time.Now().Add(15 * time.Minute).Unix()
-	// payload := map[string]interface{}{
-	// 	"username": username,
-	// 	"email":    email,
-	// 	"exp":      expirationTime,
-	// }
-	// payloadJson, _ := json.Marshal(payload)
-	// payloadEncoded := base64.RawURLEncoding.EncodeToString(payloadJson)
-
-	// dataToSign := headerEncoded + "." + payloadEncoded
-
-	// mac := hmac.New(sha256.New, secretKey)
-	// mac.Write([]byte(dataToSign))
-	// signature := mac.Sum(nil)
-	// signatureEncoded := base64.RawURLEncoding.EncodeToString(signature)
-
-	// accessToken := dataToSign + "." + signatureEncoded
-
-	// refreshToken, err := generateRandomToken(32)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// refreshTokenExpiresAt := time.Now().Add(30 * 24 * time.Hour)
+func (r *PostgresTokenRepository) CreateToken(ctx context.Context, token *model.RefreshToken) error {
+	return insertRefreshToken(ctx, r.db, token)
+}
+
+// execer is what *sql.DB and *sql.Tx have in common for writes
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertRefreshToken stores token, inside a transaction when db is one
+func insertRefreshToken(ctx context.Context, db execer, token *model.RefreshToken) error {
 	query := `
-		WITH existing_user AS (
-			SELECT id
-			FROM users
-			WHERE email = $3 AND password = $4
-		)
-		INSERT INTO refresh_tokens (user_id, refresh_token, expires_at)
-		SELECT id, $1, $2
-		FROM existing_user
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, scope, client_id, ip, user_agent, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8)
 	`
-	res, err := r.db.ExecContext(ctx, query, refreshToken, expiresAt, email, clientSecret)
+	_, err := db.ExecContext(ctx, query, token.UserID, token.TokenHash, token.FamilyID, token.Scope, token.ClientID, token.IP, token.UserAgent, token.ExpiresAt)
+	return err
+}
+
+func (r *PostgresTokenRepository) RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt func(clientID string) time.Time, check func(*model.RefreshToken) error, info model.RequestInfo) (*model.RefreshToken, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		old        model.RefreshToken
+		replacedBy sql.NullString
+		revokedAt  sql.NullTime
+	)
+	query := `
+		SELECT rt.user_id, rt.family_id, rt.scope, COALESCE(rt.client_id, ''), rt.expires_at, rt.replaced_by, rt.revoked_at, u.email, u.role
+		FROM refresh_tokens rt
+		JOIN users u ON u.id = rt.user_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt
+	`
+	err = tx.QueryRowContext(ctx, query, oldHash).Scan(&old.UserID, &old.FamilyID, &old.Scope, &old.ClientID, &old.ExpiresAt, &replacedBy, &revokedAt, &old.Email, &old.Role)
+	if err == sql.ErrNoRows {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		if !replacedBy.Valid {
+			return nil, ErrRefreshTokenRevoked
+		}
+		// A rotated token came back: someone kept a copy, so nothing in the
+		// family can be trusted any more.
+		if err := revokeFamily(ctx, tx, old.FamilyID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(old.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+	if err := check(&old); err != nil {
+		return nil, err
+	}
+
+	rotated := &model.RefreshToken{
+		TokenHash: newHash,
+		UserID:    old.UserID,
+		Email:     old.Email,
+		Role:      old.Role,
+		FamilyID:  old.FamilyID,
+		Scope:     old.Scope,
+		ClientID:  old.ClientID,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+		ExpiresAt: expiresAt(old.ClientID),
 	}
-	rowsAffected, err := res.RowsAffected()
+	if err := insertRefreshToken(ctx, tx, rotated); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = now(), replaced_by = $2
+		WHERE token_hash = $1
+	`, oldHash, newHash)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found or credentials invalid")
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
-	return nil
+	return rotated, nil
 }
 
-// func generateRandomToken(length int) (string, error) {
-// 	bytes := make([]byte, length)
-// 	_, err := rand.Read(bytes)
-// 	if err != nil {
-// 		return "", err
-// 	}
-// 	return base64.RawURLEncoding.EncodeToString(bytes), nil
-// }
+// revokeFamily revokes every live refresh token descended from the same login
+func revokeFamily(ctx context.Context, tx *sql.Tx, familyID string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = now()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID)
+	return err
+}
+
+func (r *PostgresTokenRepository) RevokeRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	query := `
+		WITH token AS (
+			SELECT rt.user_id, u.email, rt.family_id, COALESCE(rt.client_id, '') AS client_id
+			FROM refresh_tokens rt
+			JOIN users u ON u.id = rt.user_id
+			WHERE rt.token_hash = $1
+		), revoked AS (
+			UPDATE refresh_tokens
+			SET revoked_at = now()
+			WHERE family_id = (SELECT family_id FROM token)
+				AND revoked_at IS NULL
+		)
+		SELECT user_id, email, family_id, client_id FROM token
+	`
+	var token model.RefreshToken
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(&token.UserID, &token.Email, &token.FamilyID, &token.ClientID)
+	if err == sql.ErrNoRows {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *PostgresTokenRepository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO revoked_access_tokens (jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, query, jti, expiresAt)
+	return err
+}
 
-// func (r *PostgresUserRepository) ValidateCredentials(ctx context.Context, clientID, clientSecret, requestedScope string) (bool, error) {
-// 	var secret string
-// 	var scopes []string
+func (r *PostgresTokenRepository) IsAccessTokenRevoked(ctx context.Context, jti, familyID string) (bool, error) {
+	// a family has no unrevoked token left once it has been logged out;
+	// rotation revokes the old token in the same transaction that adds the new one
+	query := `
+		SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1)
+			OR ($2 <> '' AND NOT EXISTS (
+				SELECT 1 FROM refresh_tokens WHERE family_id = $2 AND revoked_at IS NULL
+			))
+	`
+	var revoked bool
+	if err := r.db.QueryRowContext(ctx, query, jti, familyID).Scan(&revoked); err != nil {
+		return false, err
+	}
+	return revoked, nil
+}
 
-// 	query := `
-// 		SELECT client_secret, scope 
-// 		FROM public.user 
-// 		WHERE client_id = $1
-// 	`
+func (r *PostgresTokenRepository) ListSessions(ctx context.Context, email string) ([]model.Session, error) {
+	query := `
+		SELECT rt.family_id, COALESCE(rt.client_id, ''), COALESCE(rt.ip, ''), COALESCE(rt.user_agent, ''),
+			(SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = rt.family_id),
+			rt.created_at, rt.expires_at
+		FROM refresh_tokens rt
+		JOIN users u ON u.id = rt.user_id
+		WHERE u.email = $1 AND rt.revoked_at IS NULL AND rt.expires_at > now()
+		ORDER BY rt.created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-// 	err := r.db.QueryRowContext(ctx, query, clientID).Scan(&secret, pq.Array(&scopes))
-// 	if err != nil {
-// 		if err == sql.ErrNoRows {
-// 			return false, nil
-// 		}
-// 		return false, err
-// 	}
+	sessions := []model.Session{}
+	for rows.Next() {
+		var session model.Session
+		if err := rows.Scan(&session.ID, &session.ClientID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
 
-// 	// Check client secret
-// 	if secret != clientSecret {
-// 		return false, nil
-// 	}
+func (r *PostgresTokenRepository) RevokeSessions(ctx context.Context, email, familyID string) (int, error) {
+	query := `
+		UPDATE refresh_tokens rt
+		SET revoked_at = now()
+		FROM users u
+		WHERE u.id = rt.user_id AND u.email = $1
+			AND ($2 = '' OR rt.family_id = $2)
+			AND rt.revoked_at IS NULL
+			AND rt.expires_at > now()
+		RETURNING rt.family_id
+	`
+	rows, err := r.db.QueryContext(ctx, query, email, familyID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
 
-// 	// Check if the requested scope is allowed
-// 	validScope := false
-// 	for _, scope := range scopes {
-// 		if scope == requestedScope {
-// 			validScope = true
-// 			break
-// 		}
-// 	}
+	families := make(map[string]bool)
+	for rows.Next() {
+		var family string
+		if err := rows.Scan(&family); err != nil {
+			return 0, err
+		}
+		families[family] = true
+	}
+	return len(families), rows.Err()
+}
 
-// 	return validScope, nil
-// }
+func (r *PostgresTokenRepository) DeleteStaleRefreshTokens(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	query := `
+		DELETE FROM refresh_tokens
+		WHERE id IN (
+			SELECT rt.id
+			FROM refresh_tokens rt
+			WHERE (rt.expires_at < $1 OR rt.revoked_at < $1)
+				AND NOT EXISTS (
+					SELECT 1 FROM refresh_tokens kept
+					WHERE kept.family_id = rt.family_id
+						AND kept.expires_at >= $1
+						AND (kept.revoked_at IS NULL OR kept.revoked_at >= $1)
+				)
+			LIMIT $2
+		)
+	`
+	return execCount(ctx, r.db, query, cutoff, limit)
+}
 
-// func (r *PostgresUserRepository) GetUserScopes(ctx context.Context, clientID string) ([]string, error) {
-// 	var scopes []string
+func (r *PostgresTokenRepository) DeleteExpiredRevocations(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	query := `
+		DELETE FROM revoked_access_tokens
+		WHERE jti IN (
+			SELECT jti FROM revoked_access_tokens WHERE expires_at < $1 LIMIT $2
+		)
+	`
+	return execCount(ctx, r.db, query, cutoff, limit)
+}
 
-// 	query := `
-// 		SELECT scope 
-// 		FROM public.user 
-// 		WHERE client_id = $1
-// 	`
+func execCount(ctx context.Context, db *sql.DB, query string, args ...interface{}) (int, error) {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
 
-// 	err := r.db.QueryRowContext(ctx, query, clientID).Scan(pq.Array(&scopes))
+// func generateRandomToken(length int) (string, error) {
+// 	bytes := make([]byte, length)
+// 	_, err := rand.Read(bytes)
 // 	if err != nil {
-// 		if err == sql.ErrNoRows {
-// 			return []string{}, nil
-// 		}
-// 		return nil, err
+// 		return "", err
 // 	}
-
-// 	return scopes, nil
-// }
\ No newline at end of file
+// 	return base64.RawURLEncoding.EncodeToString(bytes), nil
+// }
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"authservice/model"
+)
+
+type PostgresUserRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresUserRepository(db *sql.DB) UserRepository {
+	return &PostgresUserRepository{db: db}
+}
+
+func (r *PostgresUserRepository) GetCredentialsByEmail(ctx context.Context, email string) (*model.UserCredentials, error) {
+	query := `
+		SELECT id, username, email, password, password_algo, role
+		FROM users
+		WHERE email = $1
+	`
+	var user model.UserCredentials
+	err := r.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.PasswordAlgo, &user.Role)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *PostgresUserRepository) UpdatePassword(ctx context.Context, userID int, currentAlgorithm, passwordHash, algorithm string) (bool, error) {
+	query := `
+		UPDATE users
+		SET password = $3, password_algo = $4
+		WHERE id = $1 AND password_algo = $2
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, currentAlgorithm, passwordHash, algorithm)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	return updated > 0, err
+}
+
+func (r *PostgresUserRepository) ListUsersByPasswordAlgorithm(ctx context.Context, algorithm string, afterID, limit int) ([]model.UserCredentials, error) {
+	query := `
+		SELECT id, username, email, password, password_algo
+		FROM users
+		WHERE password_algo = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3
+	`
+	rows, err := r.db.QueryContext(ctx, query, algorithm, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []model.UserCredentials
+	for rows.Next() {
+		var user model.UserCredentials
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.PasswordAlgo); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"authservice/model"
+	"authservice/repository"
+)
+
+// Types of audit events
+const (
+	AuditLogin          = "login"
+	AuditTokenIssued    = "token_issued"
+	AuditTokenRefreshed = "token_refreshed"
+	AuditTokenRevoked   = "token_revoked"
+	AuditSessionRevoked = "session_revoked"
+	AuditImpersonation  = "impersonation"
+	AuditKeyRotated     = "key_rotated"
+)
+
+// Outcomes of audit events
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// MaxAuditEvents is the largest page ListAuditEvents returns
+const MaxAuditEvents = 1000
+
+var auditRepo repository.AuditRepository
+
+// InitAuditLog sets where audit events are written. Without it events are
+// only dropped.
+func InitAuditLog(repo repository.AuditRepository) {
+	auditRepo = repo
+}
+
+// recordAudit appends event to the audit log. The write outlives a cancelled
+// request so that failures the client gave up on are still recorded. A
+// failed write is logged but does not fail the request it belongs to.
+func recordAudit(ctx context.Context, event model.AuditEvent) {
+	if auditRepo == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Second)
+	defer cancel()
+	if err := auditRepo.AppendAuditEvent(ctx, &event); err != nil {
+		log.Printf("audit event %s for %q not written: %v", event.Type, event.Email, err)
+	}
+}
+
+// auditOutcome maps an error to the outcome of an audit event
+func auditOutcome(err error) string {
+	if err != nil {
+		return AuditFailure
+	}
+	return AuditSuccess
+}
+
+// auditActor names who holds the claims: the user's email or the client
+func auditActor(claims *model.AccessTokenClaims) string {
+	if claims.Email != "" {
+		return claims.Email
+	}
+	return "client:" + claims.ClientID
+}
+
+// ListAuditEvents returns audit events matching filter, newest first
+func ListAuditEvents(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error) {
+	if filter.Limit <= 0 || filter.Limit > MaxAuditEvents {
+		filter.Limit = MaxAuditEvents
+	}
+	return auditRepo.ListAuditEvents(ctx, filter)
+}
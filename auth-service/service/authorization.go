@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"time"
+
+	"authservice/model"
+	"authservice/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidRedirectURI      = errors.New("redirect_uri is not registered for this client")
+	ErrUnsupportedResponseType = errors.New("unsupported response_type")
+	ErrPKCERequired            = errors.New("code_challenge with code_challenge_method S256 is required")
+	ErrInvalidGrant            = errors.New("invalid authorization grant")
+)
+
+const authorizationCodeTTL = 2 * time.Minute
+
+// ValidateAuthorizationRequest checks an /authorize request before the user
+// is asked to log in. ErrInvalidClient and ErrInvalidRedirectURI mean the
+// redirect_uri cannot be trusted and the error must not be sent to it.
+func (s *TokenService) ValidateAuthorizationRequest(ctx context.Context, req *model.AuthorizationRequest) (*model.Client, error) {
+	client, err := s.clientRepo.GetClient(ctx, req.ClientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrClientNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	if req.ResponseType != "code" {
+		return client, ErrUnsupportedResponseType
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return client, ErrPKCERequired
+	}
+	if req.Scope, err = allowedScope(client, req.Scope); err != nil {
+		return client, err
+	}
+	return client, nil
+}
+
+// Authorize logs the user in and returns a single-use authorization code
+// bound to the request's client, redirect_uri and code_challenge
+func (s *TokenService) Authorize(ctx context.Context, req *model.AuthorizationRequest, email, password string, info model.RequestInfo) (string, error) {
+	if _, err := s.ValidateAuthorizationRequest(ctx, req); err != nil {
+		return "", err
+	}
+
+	user, err := s.authenticateUser(ctx, email, password, info)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = s.codeRepo.CreateAuthorizationCode(ctx, &model.AuthorizationCode{
+		CodeHash:      hashToken(code),
+		ClientID:      req.ClientID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ExchangeAuthorizationCode redeems a code at the token endpoint. Public
+// clients prove possession with the PKCE code_verifier alone; confidential
+// clients must also authenticate.
+func (s *TokenService) ExchangeAuthorizationCode(ctx context.Context, clientID, clientSecret, code, redirectURI, codeVerifier string, info model.RequestInfo) (*model.TokenResponse, error) {
+	client, err := s.clientRepo.GetClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrClientNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if !client.Public && bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(clientSecret)) != nil {
+		return nil, ErrInvalidClient
+	}
+
+	familyID, err := generateRandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	// the code is only spent once it is presented by its client with the
+	// right redirect_uri and verifier, so a stolen code cannot be burnt, and
+	// only together with the tokens issued for it, so a failure to issue
+	// them leaves the code for the client to try again
+	var (
+		grant    userGrant
+		response *model.TokenResponse
+	)
+	_, err = s.codeRepo.ConsumeAuthorizationCode(ctx, hashToken(code), func(authCode *model.AuthorizationCode) (*model.RefreshToken, error) {
+		if authCode.ClientID != client.ClientID || authCode.RedirectURI != redirectURI || time.Now().After(authCode.ExpiresAt) {
+			return nil, ErrInvalidGrant
+		}
+		if !verifyCodeChallenge(authCode.CodeChallenge, codeVerifier) {
+			return nil, ErrInvalidGrant
+		}
+
+		grant = userGrant{
+			grantType: "authorization_code",
+			userID:    authCode.UserID,
+			email:     authCode.Email,
+			role:      authCode.Role,
+			scope:     authCode.Scope,
+			clientID:  authCode.ClientID,
+			nonce:     authCode.Nonce,
+			familyID:  familyID,
+			info:      info,
+		}
+		refreshToken, row, err := newRefreshToken(grant)
+		if err != nil {
+			return nil, err
+		}
+		if response, err = newUserTokenResponse(grant, refreshToken); err != nil {
+			return nil, err
+		}
+		return row, nil
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrAuthorizationCodeNotFound) || errors.Is(err, repository.ErrAuthorizationCodeReused) {
+			return nil, ErrInvalidGrant
+		}
+		return nil, err
+	}
+
+	auditTokenIssued(ctx, grant)
+	return response, nil
+}
+
+// verifyCodeChallenge implements the S256 method of RFC 7636
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	verifier := strings.Repeat("a", 43)
+
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		want      bool
+	}{
+		{name: "S256", challenge: s256(verifier), verifier: verifier, want: true},
+		{name: "wrong verifier", challenge: s256(verifier), verifier: strings.Repeat("b", 43), want: false},
+		// plain would let a challenge seen in the authorize request redeem the code
+		{name: "plain rejected", challenge: verifier, verifier: verifier, want: false},
+		{name: "too short", challenge: s256("short"), verifier: "short", want: false},
+		{name: "too long", challenge: s256(strings.Repeat("a", 129)), verifier: strings.Repeat("a", 129), want: false},
+		{name: "empty challenge", challenge: "", verifier: verifier, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyCodeChallenge(tt.challenge, tt.verifier); got != tt.want {
+				t.Errorf("verifyCodeChallenge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"authservice/model"
+	"authservice/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrAuthorizationPending = errors.New("the user has not yet approved the device")
+	ErrSlowDown             = errors.New("the device polls too often")
+	ErrAccessDenied         = errors.New("the user denied the device")
+	ErrDeviceCodeExpired    = errors.New("device code has expired")
+	ErrInvalidUserCode      = errors.New("user code is invalid or expired")
+)
+
+// DeviceCodeGrantType is the grant_type devices poll /token with
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+const (
+	// devicePollInterval is how long a device waits between polls of /token
+	devicePollInterval = 5 * time.Second
+	// userCodeAlphabet has no vowels, so codes do not spell words, and no
+	// characters that are easily confused on a TV screen
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// DeviceService implements the OAuth device authorization grant (RFC 8628)
+// for clients without a keyboard: the device shows a short user code, the
+// user approves it on a phone or computer and the device polls /token.
+type DeviceService struct {
+	tokenService    *TokenService
+	deviceRepo      repository.DeviceCodeRepository
+	verificationURI string
+	ttl             time.Duration
+}
+
+// NewDeviceService creates a DeviceService. verificationURI is the page
+// users enter the user code on.
+func NewDeviceService(
+	tokenService *TokenService,
+	deviceRepo repository.DeviceCodeRepository,
+	verificationURI string,
+	ttl time.Duration,
+) *DeviceService {
+	return &DeviceService{
+		tokenService:    tokenService,
+		deviceRepo:      deviceRepo,
+		verificationURI: verificationURI,
+		ttl:             ttl,
+	}
+}
+
+// StartDeviceAuthorization issues a device code and user code to a client.
+// Public clients are identified by client_id alone, confidential clients
+// must authenticate.
+func (s *DeviceService) StartDeviceAuthorization(ctx context.Context, clientID, clientSecret, scope string) (*model.DeviceAuthorizationResponse, error) {
+	client, err := s.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if scope, err = allowedScope(client, scope); err != nil {
+		return nil, err
+	}
+
+	deviceCode, err := generateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	code := &model.DeviceCode{
+		DeviceCodeHash: hashToken(deviceCode),
+		ClientID:       client.ClientID,
+		Scope:          scope,
+		Interval:       devicePollInterval,
+		ExpiresAt:      time.Now().Add(s.ttl),
+	}
+	for attempt := 0; ; attempt++ {
+		if code.UserCode, err = generateUserCode(); err != nil {
+			return nil, err
+		}
+		err = s.deviceRepo.CreateDeviceCode(ctx, code)
+		if !errors.Is(err, repository.ErrUserCodeTaken) || attempt == 2 {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	complete, err := url.Parse(s.verificationURI)
+	if err != nil {
+		return nil, err
+	}
+	query := complete.Query()
+	query.Set("user_code", FormatUserCode(code.UserCode))
+	complete.RawQuery = query.Encode()
+
+	return &model.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                FormatUserCode(code.UserCode),
+		VerificationURI:         s.verificationURI,
+		VerificationURIComplete: complete.String(),
+		ExpiresIn:               int64(s.ttl.Seconds()),
+		Interval:                int64(devicePollInterval.Seconds()),
+	}, nil
+}
+
+// PendingDevice returns the device authorization a user code belongs to so
+// the user can see which client is asking before approving it
+func (s *DeviceService) PendingDevice(ctx context.Context, userCode string) (*model.DeviceCode, error) {
+	code, err := s.deviceRepo.GetPendingDeviceCode(ctx, NormalizeUserCode(userCode))
+	if err != nil {
+		if errors.Is(err, repository.ErrDeviceCodeNotFound) {
+			return nil, ErrInvalidUserCode
+		}
+		return nil, err
+	}
+	return code, nil
+}
+
+// ResolveDevice logs the user in and approves or denies the device the user
+// code belongs to
+func (s *DeviceService) ResolveDevice(ctx context.Context, userCode, email, password string, approve bool, info model.RequestInfo) error {
+	user, err := s.tokenService.authenticateUser(ctx, email, password, info)
+	if err != nil {
+		return err
+	}
+	err = s.deviceRepo.ResolveDeviceCode(ctx, NormalizeUserCode(userCode), user.ID, approve)
+	if errors.Is(err, repository.ErrDeviceCodeNotFound) {
+		return ErrInvalidUserCode
+	}
+	return err
+}
+
+// ExchangeDeviceCode answers a poll of the device at the token endpoint.
+// Until the user decides it returns ErrAuthorizationPending; once approved
+// the device gets a token response, exactly once.
+func (s *DeviceService) ExchangeDeviceCode(ctx context.Context, clientID, clientSecret, deviceCode string, info model.RequestInfo) (*model.TokenResponse, error) {
+	client, err := s.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	familyID, err := generateRandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	code, err := s.deviceRepo.PollDeviceCode(ctx, hashToken(deviceCode), client.ClientID, familyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrDeviceCodeNotFound):
+			return nil, ErrInvalidGrant
+		case errors.Is(err, repository.ErrDeviceCodeSlowDown):
+			return nil, ErrSlowDown
+		}
+		return nil, err
+	}
+
+	if time.Now().After(code.ExpiresAt) {
+		return nil, ErrDeviceCodeExpired
+	}
+	switch code.Status {
+	case model.DeviceCodePending:
+		return nil, ErrAuthorizationPending
+	case model.DeviceCodeDenied:
+		return nil, ErrAccessDenied
+	case model.DeviceCodeApproved:
+	default:
+		return nil, ErrInvalidGrant
+	}
+
+	return s.tokenService.issueUserTokens(ctx, userGrant{
+		grantType: "device_code",
+		userID:    code.UserID,
+		email:     code.Email,
+		role:      code.Role,
+		scope:     code.Scope,
+		clientID:  code.ClientID,
+		familyID:  familyID,
+		info:      info,
+	})
+}
+
+func (s *DeviceService) authenticateClient(ctx context.Context, clientID, clientSecret string) (*model.Client, error) {
+	client, err := s.tokenService.clientRepo.GetClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrClientNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if !client.Public && bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(clientSecret)) != nil {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// NormalizeUserCode accepts a user code the way people type it: in lower
+// case, with or without the dash and spaces
+func NormalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(userCode)))
+}
+
+// FormatUserCode splits a user code in two halves for display, e.g. WDJB-MJHT
+func FormatUserCode(userCode string) string {
+	if len(userCode) != userCodeLength {
+		return userCode
+	}
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
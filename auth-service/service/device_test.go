@@ -0,0 +1,41 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		userCode string
+		want     string
+	}{
+		{name: "as displayed", userCode: "WDJB-MJHT", want: "WDJBMJHT"},
+		{name: "lower case", userCode: "wdjb-mjht", want: "WDJBMJHT"},
+		{name: "no dash", userCode: "wdjbmjht", want: "WDJBMJHT"},
+		{name: "spaces", userCode: "  wdjb mjht ", want: "WDJBMJHT"},
+		{name: "tab around", userCode: "\tWDJB-MJHT\n", want: "WDJBMJHT"},
+		{name: "empty", userCode: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeUserCode(tt.userCode); got != tt.want {
+				t.Errorf("NormalizeUserCode(%q) = %q, want %q", tt.userCode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserCodeRoundTrip(t *testing.T) {
+	userCode, err := generateUserCode()
+	if err != nil {
+		t.Fatalf("generateUserCode: %v", err)
+	}
+	if len(userCode) != userCodeLength || strings.Trim(userCode, userCodeAlphabet) != "" {
+		t.Fatalf("generateUserCode() = %q, want %d letters of %q", userCode, userCodeLength, userCodeAlphabet)
+	}
+	if got := NormalizeUserCode(strings.ToLower(FormatUserCode(userCode))); got != userCode {
+		t.Errorf("NormalizeUserCode(FormatUserCode(%q)) = %q", userCode, got)
+	}
+}
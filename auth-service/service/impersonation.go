@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"authservice/model"
+	"authservice/repository"
+)
+
+// Token exchange (RFC 8693) identifiers
+const (
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	AccessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var (
+	ErrImpersonationForbidden = errors.New("the token may not impersonate users")
+	ErrInvalidTarget          = errors.New("the requested subject cannot be impersonated")
+)
+
+// ImpersonationService lets support staff see what a player sees. A support
+// agent exchanges their own access token for a short-lived token of the
+// player that names the agent in its act claim. Every attempt by a valid
+// agent token is written to the impersonation audit trail.
+type ImpersonationService struct {
+	tokenService *TokenService
+	auditRepo    repository.ImpersonationRepository
+	ttl          time.Duration
+}
+
+// NewImpersonationService creates an ImpersonationService issuing tokens
+// that live at most ttl
+func NewImpersonationService(tokenService *TokenService, auditRepo repository.ImpersonationRepository, ttl time.Duration) *ImpersonationService {
+	return &ImpersonationService{
+		tokenService: tokenService,
+		auditRepo:    auditRepo,
+		ttl:          ttl,
+	}
+}
+
+// Impersonate exchanges the agent's subjectToken for an access token of the
+// player with email requestedSubject. Only tokens with the users:impersonate
+// permission may do so, only players can be impersonated, and the new token
+// has no refresh token and no money permissions.
+func (s *ImpersonationService) Impersonate(ctx context.Context, subjectToken, requestedSubject, scope, reason string, info model.RequestInfo) (*model.TokenResponse, error) {
+	agent, err := s.tokenService.VerifyAccessToken(ctx, subjectToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrTokenRevoked) || errors.Is(err, ErrInvalidAudience) {
+			return nil, ErrInvalidGrant
+		}
+		return nil, err
+	}
+	agentID, err := strconv.Atoi(agent.Subject)
+	if err != nil || agent.Email == "" {
+		// client tokens have no user to hold accountable
+		return nil, ErrImpersonationForbidden
+	}
+
+	event := &model.ImpersonationEvent{
+		ActorUserID:      agentID,
+		RequestedSubject: requestedSubject,
+		ClientID:         agent.ClientID,
+		Reason:           reason,
+		Outcome:          "denied",
+		IP:               info.IP,
+		UserAgent:        info.UserAgent,
+	}
+	response, err := s.impersonate(ctx, agent, event, scope)
+	recordAudit(ctx, model.AuditEvent{
+		Type:      AuditImpersonation,
+		Outcome:   auditOutcome(err),
+		UserID:    event.TargetUserID,
+		Email:     requestedSubject,
+		Actor:     agent.Email,
+		ClientID:  agent.ClientID,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+		Detail:    "jti=" + event.TokenID + " reason=" + reason,
+	})
+	if auditErr := s.auditRepo.RecordImpersonation(ctx, event); auditErr != nil {
+		// a token nobody can account for must not be handed out
+		log.Printf("impersonation audit failed: %v", auditErr)
+		return nil, auditErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("user %d impersonates user %d until %s", event.ActorUserID, event.TargetUserID, event.ExpiresAt.Format(time.RFC3339))
+	return response, nil
+}
+
+func (s *ImpersonationService) impersonate(ctx context.Context, agent *model.AccessTokenClaims, event *model.ImpersonationEvent, scope string) (*model.TokenResponse, error) {
+	// tokens that are already impersonating cannot be chained
+	if agent.Impersonated() || !HasPermission(agent, PermissionUsersImpersonate) {
+		return nil, ErrImpersonationForbidden
+	}
+	scope, err := userScope(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := s.tokenService.userRepo.GetCredentialsByEmail(ctx, event.RequestedSubject)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrInvalidTarget
+		}
+		return nil, err
+	}
+	event.TargetUserID = target.ID
+	if target.Role != RolePlayer || target.ID == event.ActorUserID {
+		return nil, ErrInvalidTarget
+	}
+
+	jti, err := generateRandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	policy := policyFor(agent.ClientID)
+	policy.AccessTokenTTL = min(policy.AccessTokenTTL, s.ttl)
+	accessToken, err := newAccessToken(model.AccessTokenClaims{
+		Subject:     strconv.Itoa(target.ID),
+		Email:       target.Email,
+		ClientID:    agent.ClientID,
+		Scope:       scope,
+		ID:          jti,
+		Role:        target.Role,
+		Permissions: impersonationPermissions(target.Role),
+		Actor:       &model.Actor{Subject: agent.Subject, Email: agent.Email},
+	}, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	event.TokenID = jti
+	event.ExpiresAt = time.Now().Add(policy.AccessTokenTTL)
+	event.Outcome = "granted"
+	return &model.TokenResponse{
+		AccessToken:     accessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(policy.AccessTokenTTL.Seconds()),
+		Scope:           scope,
+		IssuedTokenType: AccessTokenType,
+	}, nil
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"authservice/model"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Values of the typ header. Access tokens are typed as in RFC 9068 so that
+// an ID token, signed with the same keys, cannot be used as one.
+const (
+	typAccessToken = "at+jwt"
+	typIDToken     = "JWT"
+)
+
+// signJWT serializes claims and signs them with the active signing key,
+// declaring the token type typ in the header
+func signJWT(typ string, claims interface{}) (string, error) {
+	key := keyRing.Active(time.Now())
+	if key == nil {
+		return "", ErrNoSigningKey
+	}
+	header := map[string]string{
+		"alg": key.Algorithm,
+		"typ": typ,
+	}
+	if key.ID != "" {
+		header["kid"] = key.ID
+	}
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	payloadJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	dataToSign := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(payloadJson)
+
+	signature, err := key.sign([]byte(dataToSign))
+	if err != nil {
+		return "", err
+	}
+	return dataToSign + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseJWT checks the type and signature of token and decodes its payload
+// into claims. It does not look at exp; callers decide what an expired token
+// means to them.
+func parseJWT(token, typ string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Typ string `json:"typ"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJson, &header); err != nil {
+		return ErrInvalidToken
+	}
+	// RFC 8725 section 3.11: typ is compared without case and may carry
+	// the application/ prefix
+	if !strings.EqualFold(strings.TrimPrefix(strings.ToLower(header.Typ), "application/"), typ) {
+		return ErrInvalidToken
+	}
+	// The algorithm has to match the key as well so a token cannot pick
+	// how it is checked.
+	key := keyRing.Lookup(header.Kid, header.Alg, time.Now())
+	if key == nil {
+		return ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return ErrInvalidToken
+	}
+
+	payloadJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(payloadJson, claims); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// JWKS returns the public keys tokens may be verified with
+func JWKS() model.JWKSet {
+	set := model.JWKSet{Keys: []model.JWK{}}
+	for _, key := range keyRing.Published(time.Now()) {
+		if jwk, ok := key.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"authservice/model"
+)
+
+// useKeys makes keys the ring of the package for the length of the test
+func useKeys(t *testing.T, keys ...ScheduledKey) {
+	t.Helper()
+	previous := keyRing
+	keyRing = &KeyRing{}
+	keyRing.Set(keys)
+	t.Cleanup(func() { keyRing = previous })
+}
+
+// craftJWT signs payload under any header, as an attacker choosing alg and
+// kid would
+func craftJWT(t *testing.T, key *SigningKey, header map[string]string, payload interface{}) string {
+	t.Helper()
+	headerJSON, _ := json.Marshal(header)
+	payloadJSON, _ := json.Marshal(payload)
+	data := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(payloadJSON)
+	signature, err := key.sign([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestParseJWT(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey := &SigningKey{ID: "ed", Algorithm: AlgEdDSA, private: private}
+	// an HMAC key whose secret an attacker could take from the public key
+	hmacKey := NewHMACKey("ed", []byte(private.Public().(ed25519.PublicKey)))
+	unknown := NewHMACKey("unknown", []byte("not in the ring"))
+	useKeys(t, ScheduledKey{Key: edKey})
+
+	claims := map[string]string{"sub": "42"}
+	signed, err := signJWT(typAccessToken, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken, err := signJWT(typIDToken, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(signed, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1"}`)) + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		token   string
+		typ     string
+		wantErr bool
+	}{
+		{"signed access token", signed, typAccessToken, false},
+		{"media type typ", craftJWT(t, edKey, map[string]string{"alg": AlgEdDSA, "kid": "ed", "typ": "application/AT+JWT"}, claims), typAccessToken, false},
+		{"ID token as access token", idToken, typAccessToken, true},
+		{"access token as ID token", signed, typIDToken, true},
+		{"missing typ", craftJWT(t, edKey, map[string]string{"alg": AlgEdDSA, "kid": "ed"}, claims), typAccessToken, true},
+		{"alg switched to HS256", craftJWT(t, hmacKey, map[string]string{"alg": AlgHS256, "kid": "ed", "typ": typAccessToken}, claims), typAccessToken, true},
+		{"alg none", strings.Join([]string{base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"ed","typ":"at+jwt"}`)), parts[1], ""}, "."), typAccessToken, true},
+		{"unknown kid", craftJWT(t, unknown, map[string]string{"alg": AlgHS256, "kid": "unknown", "typ": typAccessToken}, claims), typAccessToken, true},
+		{"tampered payload", tampered, typAccessToken, true},
+		{"not a JWT", "abc.def", typAccessToken, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			err := parseJWT(tt.token, tt.typ, &got)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("parseJWT error = %v, want %v", err, ErrInvalidToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseJWT: %v", err)
+			}
+			if got["sub"] != "42" {
+				t.Errorf("sub = %q, want 42", got["sub"])
+			}
+		})
+	}
+}
+
+func TestVerifyAccessTokenExpiry(t *testing.T) {
+	useKeys(t, ScheduledKey{Key: NewHMACKey("", []byte("secret"))})
+	previousIssuer := issuer
+	issuer = "http://auth.test"
+	t.Cleanup(func() { issuer = previousIssuer })
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		claims  model.AccessTokenClaims
+		wantErr error
+	}{
+		{"valid", model.AccessTokenClaims{Issuer: issuer, Audience: model.Audience{issuer}, ExpiresAt: now.Add(time.Minute).Unix()}, nil},
+		{"expired", model.AccessTokenClaims{Issuer: issuer, Audience: model.Audience{issuer}, ExpiresAt: now.Add(-time.Minute).Unix()}, ErrTokenExpired},
+		{"other issuer", model.AccessTokenClaims{Issuer: "http://elsewhere.test", Audience: model.Audience{issuer}, ExpiresAt: now.Add(time.Minute).Unix()}, ErrInvalidToken},
+		{"other audience", model.AccessTokenClaims{Issuer: issuer, Audience: model.Audience{"bets-api"}, ExpiresAt: now.Add(time.Minute).Unix()}, ErrInvalidAudience},
+	}
+	s := &TokenService{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := signJWT(typAccessToken, tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.VerifyAccessToken(context.Background(), token)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyAccessToken error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"authservice/model"
+)
+
+var ErrNoSigningKey = errors.New("no active signing key")
+
+// ScheduledKey is a key together with the window in which it is used.
+// From load until RetireAt the key verifies tokens and is published in the
+// JWKS; from ActivateAt it may also sign. A zero RetireAt never retires.
+type ScheduledKey struct {
+	Key        *SigningKey
+	ActivateAt time.Time
+	RetireAt   time.Time
+	// verifyOnly marks a key kept after it was replaced; it never signs
+	verifyOnly bool
+}
+
+func (k ScheduledKey) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+// KeyRing holds every key auth-service currently knows about. Of the keys
+// that are activated and not retired, the most recently activated one signs.
+type KeyRing struct {
+	mu       sync.RWMutex
+	keys     []ScheduledKey
+	activeID string
+}
+
+// Set replaces the keys in the ring
+func (r *KeyRing) Set(keys []ScheduledKey) {
+	r.mu.Lock()
+	r.keys = keys
+	r.mu.Unlock()
+}
+
+// Replace swaps the keys in the ring for keys. Keys of the old ring that keys
+// no longer names stay for verification only, until retireAt at the latest,
+// so tokens they signed keep working until they expire. Old keys that never
+// signed anything, or have retired by now, are dropped.
+func (r *KeyRing) Replace(keys []ScheduledKey, retireAt, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	named := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		named[key.Key.Algorithm+":"+key.Key.ID] = true
+	}
+	replaced := keys
+	for _, key := range r.keys {
+		signed := key.verifyOnly || !now.Before(key.ActivateAt)
+		if named[key.Key.Algorithm+":"+key.Key.ID] || !signed || key.retired(now) {
+			continue
+		}
+		if key.RetireAt.IsZero() || key.RetireAt.After(retireAt) {
+			key.RetireAt = retireAt
+		}
+		key.verifyOnly = true
+		replaced = append(replaced, key)
+	}
+	r.keys = replaced
+}
+
+// Add puts one more key in the ring
+func (r *KeyRing) Add(key ScheduledKey) {
+	r.mu.Lock()
+	r.keys = append(r.keys, key)
+	r.mu.Unlock()
+}
+
+// RetireBy makes every key in the ring retire no later than at
+func (r *KeyRing) RetireBy(at time.Time) {
+	r.mu.Lock()
+	for i, key := range r.keys {
+		if key.RetireAt.IsZero() || key.RetireAt.After(at) {
+			r.keys[i].RetireAt = at
+		}
+	}
+	r.mu.Unlock()
+}
+
+// Active returns the key new tokens are signed with
+func (r *KeyRing) Active(now time.Time) *SigningKey {
+	r.mu.RLock()
+	var active *ScheduledKey
+	for i, key := range r.keys {
+		if key.verifyOnly || now.Before(key.ActivateAt) || key.retired(now) {
+			continue
+		}
+		if active == nil || key.ActivateAt.After(active.ActivateAt) {
+			active = &r.keys[i]
+		}
+	}
+	lastID := r.activeID
+	r.mu.RUnlock()
+
+	if active == nil {
+		return nil
+	}
+	if active.Key.ID != lastID {
+		r.mu.Lock()
+		previous, promoted := r.activeID, r.activeID != active.Key.ID
+		if promoted {
+			log.Printf("signing key promoted kid=%q alg=%s previous=%q", active.Key.ID, active.Key.Algorithm, previous)
+			r.activeID = active.Key.ID
+		}
+		r.mu.Unlock()
+		// the first key after a start is not a rotation
+		if promoted && previous != "" {
+			recordAudit(context.Background(), model.AuditEvent{
+				Type:    AuditKeyRotated,
+				Outcome: AuditSuccess,
+				Actor:   "system",
+				Detail:  fmt.Sprintf("kid=%q alg=%s previous=%q", active.Key.ID, active.Key.Algorithm, previous),
+			})
+		}
+	}
+	return active.Key
+}
+
+// Lookup finds a non-retired key by kid and algorithm
+func (r *KeyRing) Lookup(kid, alg string, now time.Time) *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, key := range r.keys {
+		if key.Key.ID == kid && key.Key.Algorithm == alg && !key.retired(now) {
+			return key.Key
+		}
+	}
+	return nil
+}
+
+// Published returns every key that tokens may currently be verified with,
+// including keys that are scheduled but not yet signing
+func (r *KeyRing) Published(now time.Time) []*SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var keys []*SigningKey
+	for _, key := range r.keys {
+		if !key.retired(now) {
+			keys = append(keys, key.Key)
+		}
+	}
+	return keys
+}
+
+type keyRingEntry struct {
+	Kid            string    `json:"kid"`
+	Alg            string    `json:"alg"`
+	PrivateKeyFile string    `json:"private_key_file"`
+	SecretEnv      string    `json:"secret_env"`
+	ActivateAt     time.Time `json:"activate_at"`
+	RetireAt       time.Time `json:"retire_at"`
+}
+
+// LoadKeyRingFile reads a JSON array of key entries, for example
+//
+//	[
+//	  {"alg": "HS256", "secret_env": "JWT_SECRET_KEY", "retire_at": "2026-11-02T00:00:00Z"},
+//	  {"kid": "2026-11", "alg": "RS256", "private_key_file": "/etc/auth-service/keys/2026-11.pem",
+//	   "activate_at": "2026-11-01T00:00:00Z"}
+//	]
+//
+// HS256 entries take their secret from the environment variable secret_env.
+func LoadKeyRingFile(path string) ([]ScheduledKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []keyRingEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := make([]ScheduledKey, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		var key *SigningKey
+		if entry.Alg == AlgHS256 {
+			secret := os.Getenv(entry.SecretEnv)
+			if entry.SecretEnv == "" || secret == "" {
+				return nil, fmt.Errorf("%s: HS256 key %q needs a non-empty secret_env", path, entry.Kid)
+			}
+			key = NewHMACKey(entry.Kid, []byte(secret))
+		} else {
+			key, err = LoadSigningKey(entry.Kid, entry.Alg, entry.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("%s: duplicate kid %q", path, key.ID)
+		}
+		seen[key.ID] = true
+		if !entry.RetireAt.IsZero() && !entry.RetireAt.After(entry.ActivateAt) {
+			return nil, fmt.Errorf("%s: key %q retires before it activates", path, key.ID)
+		}
+		keys = append(keys, ScheduledKey{Key: key, ActivateAt: entry.ActivateAt, RetireAt: entry.RetireAt})
+	}
+	return keys, nil
+}
+
+// WatchKeyRingFile reloads the key ring from path every interval so keys can
+// be scheduled without a restart. A file that fails to load is logged and the
+// previous keys stay in place.
+func WatchKeyRingFile(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastErr string
+	for range ticker.C {
+		keys, err := LoadKeyRingFile(path)
+		if err != nil {
+			log.Printf("key ring reload failed: %v", err)
+			// audit a broken file once, not on every reload
+			if err.Error() != lastErr {
+				recordAudit(context.Background(), model.AuditEvent{
+					Type:    AuditKeyRotated,
+					Outcome: AuditFailure,
+					Actor:   "system",
+					Detail:  "key ring reload: " + err.Error(),
+				})
+			}
+			lastErr = err.Error()
+			continue
+		}
+		lastErr = ""
+		now := time.Now()
+		keyRing.Replace(keys, now.Add(maxAccessTokenTTL()), now)
+	}
+}
+
+func describeKeys(keys []ScheduledKey) string {
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, fmt.Sprintf("%q(%s)", key.Key.ID, key.Key.Algorithm))
+	}
+	return strings.Join(ids, ", ")
+}
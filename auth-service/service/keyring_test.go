@@ -0,0 +1,110 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyRingSchedule(t *testing.T) {
+	now := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	old := NewHMACKey("old", []byte("old secret"))
+	current := NewHMACKey("current", []byte("current secret"))
+	next := NewHMACKey("next", []byte("next secret"))
+
+	ring := &KeyRing{}
+	ring.Set([]ScheduledKey{
+		{Key: old, ActivateAt: now.Add(-48 * time.Hour), RetireAt: now.Add(-time.Hour)},
+		{Key: current, ActivateAt: now.Add(-24 * time.Hour)},
+		{Key: next, ActivateAt: now.Add(time.Hour)},
+	})
+
+	tests := []struct {
+		name       string
+		at         time.Time
+		wantActive *SigningKey
+		wantLookup map[*SigningKey]bool
+	}{
+		{"before next activates", now, current, map[*SigningKey]bool{old: false, current: true, next: true}},
+		{"after next activates", now.Add(2 * time.Hour), next, map[*SigningKey]bool{old: false, current: true, next: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ring.Active(tt.at); got != tt.wantActive {
+				t.Errorf("Active = %v, want %q", got, tt.wantActive.ID)
+			}
+			for key, want := range tt.wantLookup {
+				if got := ring.Lookup(key.ID, AlgHS256, tt.at) != nil; got != want {
+					t.Errorf("Lookup(%q) found = %t, want %t", key.ID, got, want)
+				}
+			}
+		})
+	}
+
+	if ring.Lookup("current", AlgRS256, now) != nil {
+		t.Error("Lookup matched a key under another algorithm")
+	}
+}
+
+func TestKeyRingRetireBy(t *testing.T) {
+	now := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	early := NewHMACKey("early", []byte("early secret"))
+	open := NewHMACKey("open", []byte("open secret"))
+
+	ring := &KeyRing{}
+	ring.Set([]ScheduledKey{
+		{Key: early, RetireAt: now.Add(10 * time.Minute)},
+		{Key: open},
+	})
+	ring.RetireBy(now.Add(time.Hour))
+
+	// a retirement already sooner is kept
+	if ring.Lookup("early", AlgHS256, now.Add(30*time.Minute)) != nil {
+		t.Error("early key still valid after its own retirement")
+	}
+	if ring.Lookup("open", AlgHS256, now.Add(30*time.Minute)) == nil {
+		t.Error("open key retired before the deadline")
+	}
+	if ring.Lookup("open", AlgHS256, now.Add(time.Hour)) != nil {
+		t.Error("open key still valid at the deadline")
+	}
+}
+
+func TestKeyRingReplaceKeepsPreviousKeys(t *testing.T) {
+	now := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	secret := NewHMACKey("", []byte("shared secret"))
+	scheduled := NewHMACKey("scheduled", []byte("scheduled secret"))
+	file := NewHMACKey("file", []byte("file secret"))
+
+	ring := &KeyRing{}
+	ring.Set([]ScheduledKey{
+		{Key: secret},
+		{Key: scheduled, ActivateAt: now.Add(time.Hour)},
+	})
+	retireAt := now.Add(75 * time.Minute)
+	ring.Replace([]ScheduledKey{{Key: file}}, retireAt, now)
+
+	if got := ring.Active(now); got != file {
+		t.Fatalf("Active = %v, want the file key", got)
+	}
+	// the replaced secret verifies what it signed until retireAt, but
+	// does not sign again
+	if ring.Lookup("", AlgHS256, retireAt.Add(-time.Second)) == nil {
+		t.Error("replaced key dropped before its tokens expired")
+	}
+	if ring.Lookup("", AlgHS256, retireAt) != nil {
+		t.Error("replaced key still valid after retireAt")
+	}
+	// a key that never signed is not kept
+	if ring.Lookup("scheduled", AlgHS256, now) != nil {
+		t.Error("replaced key that never signed was kept")
+	}
+
+	// reloading keeps the earlier retirement instead of extending it
+	ring.Replace([]ScheduledKey{{Key: file}}, now.Add(3*time.Hour), now.Add(time.Minute))
+	if ring.Lookup("", AlgHS256, retireAt) != nil {
+		t.Error("reload extended the retirement of a replaced key")
+	}
+	if got := ring.Active(now.Add(2 * time.Hour)); got != file {
+		t.Errorf("Active after reload = %v, want the file key", got)
+	}
+}
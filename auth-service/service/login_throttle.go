@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"authservice/repository"
+)
+
+// LoginThrottle slows down password guessing. Failures are counted per email
+// and per client IP in Postgres so every replica sees the same state. Once a
+// key reaches its limit it is locked, and each further failure doubles the
+// lockout up to maxLockout.
+type LoginThrottle struct {
+	repo        repository.LoginAttemptRepository
+	maxPerEmail int
+	maxPerIP    int
+	baseLockout time.Duration
+	maxLockout  time.Duration
+}
+
+func NewLoginThrottle(repo repository.LoginAttemptRepository, maxPerEmail, maxPerIP int, baseLockout, maxLockout time.Duration) *LoginThrottle {
+	return &LoginThrottle{
+		repo:        repo,
+		maxPerEmail: maxPerEmail,
+		maxPerIP:    maxPerIP,
+		baseLockout: baseLockout,
+		maxLockout:  maxLockout,
+	}
+}
+
+// Check returns how long the caller has to wait before trying again, or
+// zero when the attempt may go ahead
+func (t *LoginThrottle) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	lockedUntil, err := t.repo.LockedUntil(ctx, []string{emailKey(email), ipKey(ip)})
+	if err != nil {
+		return 0, err
+	}
+	if lockedUntil.IsZero() {
+		return 0, nil
+	}
+	return time.Until(lockedUntil), nil
+}
+
+// RecordFailure counts a failed login for both the email and the IP
+func (t *LoginThrottle) RecordFailure(ctx context.Context, email, ip string) error {
+	if err := t.recordFailure(ctx, emailKey(email), t.maxPerEmail); err != nil {
+		return err
+	}
+	return t.recordFailure(ctx, ipKey(ip), t.maxPerIP)
+}
+
+// RecordSuccess clears the failures for email. The IP keeps its count so a
+// stuffing run cannot reset itself with one known-good account.
+func (t *LoginThrottle) RecordSuccess(ctx context.Context, email string) error {
+	return t.repo.Reset(ctx, emailKey(email))
+}
+
+func (t *LoginThrottle) recordFailure(ctx context.Context, key string, limit int) error {
+	failures, err := t.repo.RecordFailure(ctx, key, t.maxLockout)
+	if err != nil {
+		return err
+	}
+	if failures < limit {
+		return nil
+	}
+
+	lockout := t.baseLockout
+	for i := limit; i < failures && lockout < t.maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > t.maxLockout {
+		lockout = t.maxLockout
+	}
+	return t.repo.Lock(ctx, key, time.Now().Add(lockout))
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// memoryLoginAttempts keeps login attempts in memory for the throttle tests
+type memoryLoginAttempts struct {
+	failures    map[string]int
+	lockedUntil map[string]time.Time
+}
+
+func newMemoryLoginAttempts() *memoryLoginAttempts {
+	return &memoryLoginAttempts{failures: map[string]int{}, lockedUntil: map[string]time.Time{}}
+}
+
+func (m *memoryLoginAttempts) LockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	var latest time.Time
+	for _, key := range keys {
+		if until := m.lockedUntil[key]; until.After(time.Now()) && until.After(latest) {
+			latest = until
+		}
+	}
+	return latest, nil
+}
+
+func (m *memoryLoginAttempts) RecordFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	m.failures[key]++
+	return m.failures[key], nil
+}
+
+func (m *memoryLoginAttempts) Lock(ctx context.Context, key string, until time.Time) error {
+	m.lockedUntil[key] = until
+	return nil
+}
+
+func (m *memoryLoginAttempts) Reset(ctx context.Context, key string) error {
+	delete(m.failures, key)
+	delete(m.lockedUntil, key)
+	return nil
+}
+
+func (m *memoryLoginAttempts) DeleteStaleLoginAttempts(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	return 0, nil
+}
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{name: "below limit", failures: 2, want: 0},
+		{name: "at limit", failures: 3, want: time.Minute},
+		{name: "one over doubles", failures: 4, want: 2 * time.Minute},
+		{name: "two over doubles again", failures: 5, want: 4 * time.Minute},
+		{name: "capped", failures: 10, want: 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			throttle := NewLoginThrottle(newMemoryLoginAttempts(), 3, 100, time.Minute, 10*time.Minute)
+			for i := 0; i < tt.failures; i++ {
+				if err := throttle.RecordFailure(ctx, "User@Example.com", "10.0.0.1"); err != nil {
+					t.Fatalf("RecordFailure: %v", err)
+				}
+			}
+
+			retryAfter, err := throttle.Check(ctx, "user@example.com ", "10.0.0.2")
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			// Retry-After is the wait Check reports, so it must match the lockout
+			if retryAfter > tt.want || retryAfter < tt.want-time.Second {
+				t.Errorf("Check() = %v, want about %v", retryAfter, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginThrottleSuccessKeepsIPCount(t *testing.T) {
+	ctx := context.Background()
+	throttle := NewLoginThrottle(newMemoryLoginAttempts(), 100, 2, time.Minute, 10*time.Minute)
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		if err := throttle.RecordFailure(ctx, email, "10.0.0.1"); err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+	}
+	if err := throttle.RecordSuccess(ctx, "c@example.com"); err != nil {
+		t.Fatalf("RecordSuccess: %v", err)
+	}
+
+	retryAfter, err := throttle.Check(ctx, "d@example.com", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if retryAfter <= 0 {
+		t.Errorf("Check() = %v, want the IP still locked", retryAfter)
+	}
+}
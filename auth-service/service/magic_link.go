@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"authservice/mail"
+	"authservice/model"
+	"authservice/repository"
+)
+
+var ErrMagicLinkInvalid = errors.New("magic link is invalid, expired or already used")
+
+// maxMagicLinks is how many links a user may be sent within one link lifetime
+const maxMagicLinks = 3
+
+// MagicLinkService implements passwordless login: a user asks for a link by
+// email and following it yields a normal token response
+type MagicLinkService struct {
+	tokenService *TokenService
+	linkRepo     repository.MagicLinkRepository
+	sender       mail.Sender
+	linkURL      string
+	ttl          time.Duration
+}
+
+// NewMagicLinkService creates a MagicLinkService. linkURL is the page the
+// emailed link points to; the token is added as the token query parameter.
+func NewMagicLinkService(
+	tokenService *TokenService,
+	linkRepo repository.MagicLinkRepository,
+	sender mail.Sender,
+	linkURL string,
+	ttl time.Duration,
+) *MagicLinkService {
+	return &MagicLinkService{
+		tokenService: tokenService,
+		linkRepo:     linkRepo,
+		sender:       sender,
+		linkURL:      linkURL,
+		ttl:          ttl,
+	}
+}
+
+// SendMagicLink emails a login link to the user with email. Unknown emails
+// and users who asked too often are ignored without an error so the caller
+// cannot tell which addresses have accounts. The mail is sent in the
+// background for the same reason. clientID, if given, must be registered:
+// the tokens get its policy.
+func (s *MagicLinkService) SendMagicLink(ctx context.Context, email, clientID, scope string) error {
+	scope, err := userScope(scope)
+	if err != nil {
+		return err
+	}
+	if clientID != "" {
+		if _, err := s.tokenService.lookupClient(ctx, clientID); err != nil {
+			return err
+		}
+	}
+
+	user, err := s.tokenService.userRepo.GetCredentialsByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	sent, err := s.linkRepo.CountMagicLinks(ctx, user.ID, now.Add(-s.ttl))
+	if err != nil {
+		return err
+	}
+	if sent >= maxMagicLinks {
+		log.Printf("magic link for user %d not sent: %d links in the last %s", user.ID, sent, s.ttl)
+		return nil
+	}
+
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return err
+	}
+	err = s.linkRepo.CreateMagicLink(ctx, &model.MagicLink{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		ClientID:  clientID,
+		Scope:     scope,
+		ExpiresAt: now.Add(s.ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(s.linkURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Follow this link to sign in:\n\n%s\n\nThe link works once and expires in %s. "+
+			"If you did not ask for it, ignore this email.\n", link, s.ttl),
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.sender.Send(ctx, msg); err != nil {
+			log.Printf("magic link mail to user %d failed: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// ExchangeMagicLink redeems the token from a magic link for an access and
+// refresh token. Each link works once.
+func (s *MagicLinkService) ExchangeMagicLink(ctx context.Context, token string, info model.RequestInfo) (*model.TokenResponse, error) {
+	link, err := s.linkRepo.ConsumeMagicLink(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, repository.ErrMagicLinkNotFound) {
+			return nil, ErrMagicLinkInvalid
+		}
+		return nil, err
+	}
+
+	familyID, err := generateRandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	return s.tokenService.issueUserTokens(ctx, userGrant{
+		grantType: "magic_link",
+		userID:    link.UserID,
+		email:     link.Email,
+		role:      link.Role,
+		scope:     link.Scope,
+		clientID:  link.ClientID,
+		familyID:  familyID,
+		info:      info,
+	})
+}
+
+// hashToken is what is stored for secrets such as magic links, device codes,
+// authorization codes and refresh tokens, so a leaked table cannot be used to
+// log in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
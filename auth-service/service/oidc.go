@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"authservice/model"
+	"authservice/repository"
+)
+
+// oidcScopes are the scopes any user may ask for without a registered client
+var oidcScopes = []string{"openid", "profile", "email"}
+
+var issuer string
+
+// InitIssuer sets the public base URL of auth-service, used as the iss claim
+// and to build the discovery document
+func InitIssuer(url string) {
+	issuer = strings.TrimSuffix(url, "/")
+}
+
+func hasScope(scope, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
+
+// newIDToken builds the OpenID Connect ID token for a grant. Without a
+// client the issuer itself is the audience.
+func newIDToken(grant userGrant) (string, error) {
+	audience := grant.clientID
+	if audience == "" {
+		audience = issuer
+	}
+	now := time.Now()
+	return signJWT(typIDToken, model.IDTokenClaims{
+		Issuer:    issuer,
+		Subject:   strconv.Itoa(grant.userID),
+		Audience:  audience,
+		ExpiresAt: now.Add(policyFor(grant.clientID).AccessTokenTTL).Unix(),
+		IssuedAt:  now.Unix(),
+		Nonce:     grant.nonce,
+		Email:     grant.email,
+	})
+}
+
+// UserInfo returns the profile of the user an access token was issued to.
+// The token must have been granted the openid scope.
+func (s *TokenService) UserInfo(ctx context.Context, accessToken string) (*model.UserInfo, error) {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Email == "" || !hasScope(claims.Scope, "openid") {
+		return nil, ErrInvalidScope
+	}
+
+	user, err := s.userRepo.GetCredentialsByEmail(ctx, claims.Email)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return &model.UserInfo{
+		Subject:           strconv.Itoa(user.ID),
+		Username:          user.Username,
+		PreferredUsername: user.Username,
+		Email:             user.Email,
+	}, nil
+}
+
+// OpenIDConfiguration returns the discovery document served at
+// /.well-known/openid-configuration
+func OpenIDConfiguration() model.OpenIDConfiguration {
+	var algorithms []string
+	for _, key := range keyRing.Published(time.Now()) {
+		if !slices.Contains(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+
+	return model.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		DeviceAuthorizationEndpoint:       issuer + "/device/code",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		RevocationEndpoint:                issuer + "/revoke",
+		IntrospectionEndpoint:             issuer + "/introspect",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials", "password", DeviceCodeGrantType, TokenExchangeGrantType},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algorithms,
+		ScopesSupported:                   oidcScopes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nonce", "email", "username", "preferred_username"},
+	}
+}
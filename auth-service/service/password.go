@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+
+	"authservice/model"
+	"authservice/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Values stored in users.password_algo
+const (
+	PasswordAlgoPlain  = "plain"
+	PasswordAlgoBcrypt = "bcrypt"
+)
+
+const bcryptCost = 12
+
+// dummyHash is compared against when the user does not exist so that a
+// missing account takes as long to reject as a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcryptCost)
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// verifyPassword reports whether password matches the stored credentials and
+// whether the stored form is outdated and should be replaced
+func verifyPassword(user *model.UserCredentials, password string) (ok, needsRehash bool) {
+	switch user.PasswordAlgo {
+	case PasswordAlgoBcrypt:
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(user.PasswordHash))
+		return true, err != nil || cost < bcryptCost
+	case PasswordAlgoPlain:
+		ok := subtle.ConstantTimeCompare([]byte(user.PasswordHash), []byte(password)) == 1
+		return ok, ok
+	default:
+		log.Printf("unknown password algorithm %q for user %d", user.PasswordAlgo, user.ID)
+		return false, false
+	}
+}
+
+// MigratePlaintextPasswords hashes every password still stored in plaintext,
+// for accounts that will not log in again any time soon. Passwords bcrypt
+// cannot hash, such as ones longer than 72 bytes, are logged and left as they
+// are. It returns the number of rows upgraded.
+func MigratePlaintextPasswords(ctx context.Context, userRepo repository.UserRepository, batchSize int) (int, error) {
+	migrated, afterID := 0, 0
+	for {
+		users, err := userRepo.ListUsersByPasswordAlgorithm(ctx, PasswordAlgoPlain, afterID, batchSize)
+		if err != nil {
+			return migrated, err
+		}
+		if len(users) == 0 {
+			return migrated, nil
+		}
+		for _, user := range users {
+			afterID = user.ID
+			hash, err := hashPassword(user.PasswordHash)
+			if err != nil {
+				log.Printf("skipping password migration for user %d: %v", user.ID, err)
+				continue
+			}
+			// a login may have upgraded the password since it was listed
+			updated, err := userRepo.UpdatePassword(ctx, user.ID, PasswordAlgoPlain, hash, PasswordAlgoBcrypt)
+			if err != nil {
+				return migrated, err
+			}
+			if updated {
+				migrated++
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+
+	"authservice/repository"
+)
+
+// reaperLockKey is the advisory lock that elects the replica running the reaper
+const reaperLockKey int64 = 0x61757468726561 // "authrea"
+
+// Reaper metrics, served with the other expvars at /debug/vars
+var reaperStats = expvar.NewMap("token_reaper")
+
+// TokenReaper periodically deletes refresh tokens, access token
+// revocations, device and authorization codes, magic links and login
+// attempts that can no longer be used. Only one replica works at a time.
+type TokenReaper struct {
+	tokenRepo   repository.TokenRepository
+	deviceRepo  repository.DeviceCodeRepository
+	codeRepo    repository.AuthorizationCodeRepository
+	linkRepo    repository.MagicLinkRepository
+	attemptRepo repository.LoginAttemptRepository
+	locker      repository.Locker
+	interval    time.Duration
+	retention   time.Duration
+	batchSize   int
+}
+
+// NewTokenReaper creates a reaper that runs every interval and deletes rows
+// retention after they expired or were revoked, batchSize rows per statement
+func NewTokenReaper(tokenRepo repository.TokenRepository, deviceRepo repository.DeviceCodeRepository, codeRepo repository.AuthorizationCodeRepository, linkRepo repository.MagicLinkRepository, attemptRepo repository.LoginAttemptRepository, locker repository.Locker, interval, retention time.Duration, batchSize int) *TokenReaper {
+	return &TokenReaper{
+		tokenRepo:   tokenRepo,
+		deviceRepo:  deviceRepo,
+		codeRepo:    codeRepo,
+		linkRepo:    linkRepo,
+		attemptRepo: attemptRepo,
+		locker:      locker,
+		interval:    interval,
+		retention:   retention,
+		batchSize:   batchSize,
+	}
+}
+
+// Run reaps every interval until ctx is cancelled
+func (r *TokenReaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reap(ctx); err != nil && ctx.Err() == nil {
+				reaperStats.Add("errors", 1)
+				log.Printf("token reaper failed: %v", err)
+			}
+		}
+	}
+}
+
+func (r *TokenReaper) reap(ctx context.Context) error {
+	unlock, acquired, err := r.locker.TryLock(ctx, reaperLockKey)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		reaperStats.Add("skipped", 1)
+		return nil
+	}
+	defer unlock()
+
+	start := time.Now()
+	cutoff := start.Add(-r.retention)
+	refreshTokens, err := r.deleteInBatches(ctx, "refresh_tokens_deleted", func(ctx context.Context) (int, error) {
+		return r.tokenRepo.DeleteStaleRefreshTokens(ctx, cutoff, r.batchSize)
+	})
+	if err != nil {
+		return err
+	}
+	revocations, err := r.deleteInBatches(ctx, "revocations_deleted", func(ctx context.Context) (int, error) {
+		return r.tokenRepo.DeleteExpiredRevocations(ctx, cutoff, r.batchSize)
+	})
+	if err != nil {
+		return err
+	}
+
+	deviceCodes, err := r.deleteInBatches(ctx, "device_codes_deleted", func(ctx context.Context) (int, error) {
+		return r.deviceRepo.DeleteExpiredDeviceCodes(ctx, cutoff, r.batchSize)
+	})
+	if err != nil {
+		return err
+	}
+	authorizationCodes, err := r.deleteInBatches(ctx, "authorization_codes_deleted", func(ctx context.Context) (int, error) {
+		return r.codeRepo.DeleteExpiredAuthorizationCodes(ctx, cutoff, r.batchSize)
+	})
+	if err != nil {
+		return err
+	}
+	magicLinks, err := r.deleteInBatches(ctx, "magic_links_deleted", func(ctx context.Context) (int, error) {
+		return r.linkRepo.DeleteExpiredMagicLinks(ctx, cutoff, r.batchSize)
+	})
+	if err != nil {
+		return err
+	}
+	loginAttempts, err := r.deleteInBatches(ctx, "login_attempts_deleted", func(ctx context.Context) (int, error) {
+		return r.attemptRepo.DeleteStaleLoginAttempts(ctx, cutoff, r.batchSize)
+	})
+	if err != nil {
+		return err
+	}
+
+	reaperStats.Add("runs", 1)
+	lastRun := new(expvar.Int)
+	lastRun.Set(start.Unix())
+	reaperStats.Set("last_run_unix", lastRun)
+	if refreshTokens+revocations+deviceCodes+authorizationCodes+magicLinks+loginAttempts > 0 {
+		log.Printf("token reaper deleted %d refresh tokens, %d revocations, %d device codes, %d authorization codes, %d magic links and %d login attempts in %s",
+			refreshTokens, revocations, deviceCodes, authorizationCodes, magicLinks, loginAttempts, time.Since(start))
+	}
+	return nil
+}
+
+// deleteInBatches calls deleteBatch until a batch comes back short, counting
+// the deleted rows under metric. Batches keep each statement and its locks short.
+func (r *TokenReaper) deleteInBatches(ctx context.Context, metric string, deleteBatch func(context.Context) (int, error)) (int, error) {
+	total := 0
+	for {
+		deleted, err := deleteBatch(ctx)
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+		reaperStats.Add(metric, int64(deleted))
+		if deleted < r.batchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"slices"
+
+	"authservice/model"
+)
+
+// Roles a user can have, see users.role
+const (
+	RolePlayer  = "player"
+	RoleSupport = "support"
+	RoleFinance = "finance"
+	RoleAdmin   = "admin"
+)
+
+// Permissions carried in the permissions claim of user access tokens
+const (
+	PermissionBetsPlace        = "bets:place"
+	PermissionBalanceWrite     = "balance:write"
+	PermissionProfileRead      = "profile:read"
+	PermissionUsersRead        = "users:read"
+	PermissionTransactionsRead = "transactions:read"
+	PermissionPayoutsWrite     = "payouts:write"
+	PermissionSessionsAdmin    = SessionAdminScope
+	PermissionUsersImpersonate = "users:impersonate"
+	PermissionAuditRead        = "audit:read"
+)
+
+// moneyPermissions move money and are never granted to impersonated tokens
+var moneyPermissions = []string{
+	PermissionBetsPlace,
+	PermissionBalanceWrite,
+	PermissionPayoutsWrite,
+}
+
+var rolePermissions = map[string][]string{
+	RolePlayer: {
+		PermissionBetsPlace,
+		PermissionBalanceWrite,
+		PermissionProfileRead,
+	},
+	RoleSupport: {
+		PermissionProfileRead,
+		PermissionUsersRead,
+		PermissionSessionsAdmin,
+		PermissionUsersImpersonate,
+	},
+	RoleFinance: {
+		PermissionProfileRead,
+		PermissionUsersRead,
+		PermissionTransactionsRead,
+		PermissionPayoutsWrite,
+	},
+	RoleAdmin: {
+		PermissionBetsPlace,
+		PermissionBalanceWrite,
+		PermissionProfileRead,
+		PermissionUsersRead,
+		PermissionTransactionsRead,
+		PermissionPayoutsWrite,
+		PermissionSessionsAdmin,
+		PermissionUsersImpersonate,
+		PermissionAuditRead,
+	},
+}
+
+// PermissionsFor returns the permissions granted to role. Unknown roles get
+// none.
+func PermissionsFor(role string) []string {
+	return slices.Clone(rolePermissions[role])
+}
+
+// impersonationPermissions returns the permissions of role without the ones
+// that move money
+func impersonationPermissions(role string) []string {
+	return slices.DeleteFunc(PermissionsFor(role), func(permission string) bool {
+		return slices.Contains(moneyPermissions, permission)
+	})
+}
+
+// HasPermission reports whether the claims grant permission, either through
+// the user's role or, for client tokens, as a scope. Impersonated tokens
+// never grant money permissions.
+func HasPermission(claims *model.AccessTokenClaims, permission string) bool {
+	if claims.Impersonated() && slices.Contains(moneyPermissions, permission) {
+		return false
+	}
+	return slices.Contains(claims.Permissions, permission) || hasScope(claims.Scope, permission)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"authservice/model"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionAdminScope lets a client manage the sessions of any user, e.g. the
+// support tooling used to lock out a hijacked account. Support and admin
+// users have it as a permission.
+const SessionAdminScope = "sessions:admin"
+
+// ListSessions returns the active logins of the user the access token
+// belongs to, or of email when the token has SessionAdminScope
+func (s *TokenService) ListSessions(ctx context.Context, accessToken, email string) ([]model.Session, error) {
+	claims, owner, err := s.sessionOwner(ctx, accessToken, email)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.tokenRepo.ListSessions(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+	return sessions, nil
+}
+
+// RevokeSessions logs out one session of the user, or all of them when
+// sessionID is empty, and returns how many were revoked. Access tokens issued
+// to those sessions stop verifying as well.
+func (s *TokenService) RevokeSessions(ctx context.Context, accessToken, email, sessionID string, info model.RequestInfo) (int, error) {
+	claims, owner, err := s.sessionOwner(ctx, accessToken, email)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked, err := s.tokenRepo.RevokeSessions(ctx, owner, sessionID)
+	recordAudit(ctx, model.AuditEvent{
+		Type:      AuditSessionRevoked,
+		Outcome:   auditOutcome(err),
+		Email:     owner,
+		Actor:     auditActor(claims),
+		ClientID:  claims.ClientID,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+		Detail:    fmt.Sprintf("session=%q count=%d", sessionID, revoked),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if sessionID != "" && revoked == 0 {
+		return 0, ErrSessionNotFound
+	}
+	if claims.Email != owner {
+		log.Printf("sessions of %s revoked by client %q: session=%q count=%d", owner, claims.ClientID, sessionID, revoked)
+	}
+	return revoked, nil
+}
+
+// sessionOwner verifies the access token and works out whose sessions it
+// may manage. Only tokens with SessionAdminScope may name another user.
+func (s *TokenService) sessionOwner(ctx context.Context, accessToken, email string) (*model.AccessTokenClaims, string, error) {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if email == "" || email == claims.Email {
+		if claims.Email == "" {
+			return nil, "", ErrInvalidScope
+		}
+		return claims, claims.Email, nil
+	}
+	if !HasPermission(claims, SessionAdminScope) {
+		return nil, "", ErrInvalidScope
+	}
+	return claims, email, nil
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"authservice/model"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is a key that can sign and verify JWTs with a single algorithm
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   crypto.Signer
+}
+
+// NewHMACKey creates an HS256 key from a shared secret
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{ID: id, Algorithm: AlgHS256, secret: secret}
+}
+
+// LoadSigningKey reads a PEM encoded RSA (RS256) or Ed25519 (EdDSA) private
+// key. When id is empty it is derived from the public key.
+func LoadSigningKey(id, algorithm, pemFile string) (*SigningKey, error) {
+	data, err := os.ReadFile(pemFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", pemFile)
+	}
+
+	var private crypto.Signer
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported private key type %T", pemFile, parsed)
+		}
+		private = signer
+	} else if rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(block.Bytes); rsaErr == nil {
+		private = rsaKey
+	} else {
+		return nil, fmt.Errorf("%s: %w", pemFile, err)
+	}
+
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != AlgRS256 {
+			return nil, fmt.Errorf("%s: RSA key cannot be used with %s", pemFile, algorithm)
+		}
+	case ed25519.PrivateKey:
+		if algorithm != AlgEdDSA {
+			return nil, fmt.Errorf("%s: Ed25519 key cannot be used with %s", pemFile, algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported private key type %T", pemFile, private)
+	}
+
+	if id == "" {
+		der, err := x509.MarshalPKIXPublicKey(private.Public())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(der)
+		id = base64.RawURLEncoding.EncodeToString(sum[:12])
+	}
+
+	return &SigningKey{ID: id, Algorithm: algorithm, private: private}, nil
+}
+
+func (k *SigningKey) sign(data []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case AlgRS256:
+		digest := sha256.Sum256(data)
+		return k.private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case AlgEdDSA:
+		return k.private.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		return nil, errors.New("unsupported signing algorithm " + k.Algorithm)
+	}
+}
+
+func (k *SigningKey) verify(data, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case AlgRS256:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k.private.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	case AlgEdDSA:
+		return ed25519.Verify(k.private.Public().(ed25519.PublicKey), data, signature)
+	default:
+		return false
+	}
+}
+
+// JWK returns the public half of the key. Shared secrets are never
+// published, so ok is false for HS256 keys.
+func (k *SigningKey) JWK() (jwk model.JWK, ok bool) {
+	switch public := k.publicKey().(type) {
+	case *rsa.PublicKey:
+		return model.JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: k.Algorithm,
+			Kid: k.ID,
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return model.JWK{
+			Kty: "OKP",
+			Use: "sig",
+			Alg: k.Algorithm,
+			Kid: k.ID,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(public),
+		}, true
+	default:
+		return model.JWK{}, false
+	}
+}
+
+func (k *SigningKey) publicKey() crypto.PublicKey {
+	if k.private == nil {
+		return nil
+	}
+	return k.private.Public()
+}
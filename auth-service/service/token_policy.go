@@ -0,0 +1,70 @@
+package service
+
+import (
+	"slices"
+	"time"
+
+	"authservice/model"
+)
+
+var (
+	defaultPolicy = model.TokenPolicy{
+		AccessTokenTTL:  75 * time.Minute,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+	}
+	clientPolicies = map[string]model.TokenPolicy{}
+)
+
+// InitTokenPolicies sets the lifetimes and audience of issued tokens.
+// Clients without an entry in perClient get defaults, and so do the fields a
+// client policy leaves empty. An empty audience means auth-service itself.
+func InitTokenPolicies(defaults model.TokenPolicy, perClient map[string]model.TokenPolicy) {
+	defaultPolicy = defaults
+	clientPolicies = perClient
+}
+
+// policyFor returns the token policy of clientID, which may be empty
+func policyFor(clientID string) model.TokenPolicy {
+	policy := defaultPolicy
+	if override, ok := clientPolicies[clientID]; ok && clientID != "" {
+		if override.AccessTokenTTL > 0 {
+			policy.AccessTokenTTL = override.AccessTokenTTL
+		}
+		if override.RefreshTokenTTL > 0 {
+			policy.RefreshTokenTTL = override.RefreshTokenTTL
+		}
+		if len(override.Audience) > 0 {
+			policy.Audience = override.Audience
+		}
+	}
+	if len(policy.Audience) == 0 {
+		policy.Audience = []string{issuer}
+	}
+	return policy
+}
+
+// maxAccessTokenTTL is the longest lifetime an access token may be issued with
+func maxAccessTokenTTL() time.Duration {
+	ttl := defaultPolicy.AccessTokenTTL
+	for _, policy := range clientPolicies {
+		ttl = max(ttl, policy.AccessTokenTTL)
+	}
+	return ttl
+}
+
+// refreshTokenExpiry gives a refresh token issued now to clientID its expiry
+func refreshTokenExpiry(clientID string) time.Time {
+	return time.Now().Add(policyFor(clientID).RefreshTokenTTL)
+}
+
+// checkRegisteredClaims rejects tokens from another issuer and, when
+// audience is set, tokens not meant for it
+func checkRegisteredClaims(claims *model.AccessTokenClaims, audience string) error {
+	if claims.Issuer != issuer {
+		return ErrInvalidToken
+	}
+	if audience != "" && !slices.Contains(claims.Audience, audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
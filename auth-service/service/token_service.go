@@ -3,14 +3,17 @@ package service
 import (
 	"context"
 	"errors"
+	"log"
 	"time"
-	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
 	"authservice/model"
 	"authservice/repository"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Common errors
@@ -18,74 +21,448 @@ var (
 	ErrInvalidCredentials = errors.New("invalid client credentials")
 	ErrTokenExpired       = errors.New("token has expired")
 	ErrTokenNotFound      = errors.New("token not found")
+	ErrTokenRevoked       = errors.New("token has been revoked")
+	ErrTokenReused        = errors.New("refresh token reuse detected")
+	ErrInvalidClient      = errors.New("invalid client")
+	ErrInvalidScope       = errors.New("requested scope is not allowed")
+	ErrInvalidAudience    = errors.New("token is not meant for this audience")
+	ErrClientMismatch     = errors.New("token was issued to another client")
 )
 
-var secretKey []byte
+var keyRing = &KeyRing{}
 
+// InitSecret makes the shared secret the HS256 signing key
 func InitSecret(secret string) {
-	secretKey = []byte(secret)
+	keyRing.Set([]ScheduledKey{{Key: NewHMACKey("", []byte(secret))}})
+}
+
+// InitSigningKey makes key the signing key. Keys set up before it stay
+// valid for verification only until the tokens they issued have expired, so
+// the HS256 secret stops being accepted once an asymmetric key takes over.
+// Call it after InitTokenPolicies.
+func InitSigningKey(key *SigningKey) {
+	now := time.Now()
+	keyRing.RetireBy(now.Add(maxAccessTokenTTL()))
+	keyRing.Add(ScheduledKey{Key: key, ActivateAt: now})
+}
+
+// InitKeyRing replaces the keys with a scheduled set, see LoadKeyRingFile.
+// Like InitSigningKey it keeps the keys set up before, such as the
+// JWT_SECRET_KEY secret, for verification until the tokens they issued have
+// expired. Call it after InitTokenPolicies.
+func InitKeyRing(keys []ScheduledKey) {
+	now := time.Now()
+	keyRing.Replace(keys, now.Add(maxAccessTokenTTL()), now)
+	log.Printf("key ring loaded: %s", describeKeys(keys))
 }
 
 type TokenService struct {
-	tokenRepo repository.TokenRepository
-	// userRepo  *repository.UserRepository
+	tokenRepo  repository.TokenRepository
+	clientRepo repository.ClientRepository
+	userRepo   repository.UserRepository
+	codeRepo   repository.AuthorizationCodeRepository
 }
 
 // NewTokenService creates a new TokenService
-func NewTokenService(tokenRepo repository.TokenRepository) *TokenService {
+func NewTokenService(
+	tokenRepo repository.TokenRepository,
+	clientRepo repository.ClientRepository,
+	userRepo repository.UserRepository,
+	codeRepo repository.AuthorizationCodeRepository,
+) *TokenService {
 	return &TokenService{
-		tokenRepo: tokenRepo,
-		// userRepo:  userRepo,
+		tokenRepo:  tokenRepo,
+		clientRepo: clientRepo,
+		userRepo:   userRepo,
+		codeRepo:   codeRepo,
 	}
 }
 
-// CreateToken creates a new access token
-func (s *TokenService) CreateToken(ctx context.Context, email, clientSecret string) (*model.TokenResponse, error) {
-	header := map[string]string{
-		"alg": "HS256",
-		"typ": "JWT",
+// CreateToken checks the user's password and issues an access/refresh pair.
+// clientID is optional; when given it must be a registered client, and a
+// confidential one has to authenticate with clientSecret, because the client
+// decides the token policy. scope may only ask for the OpenID Connect scopes.
+func (s *TokenService) CreateToken(ctx context.Context, email, password, clientID, clientSecret, scope string, info model.RequestInfo) (*model.TokenResponse, error) {
+	scope, err := userScope(scope)
+	if err != nil {
+		return nil, err
 	}
-	headerJson, _ := json.Marshal(header)
-	headerEncoded := base64.RawURLEncoding.EncodeToString(headerJson)
 
-	expirationTime := time.Now().Add(75 * time.Minute).Unix()
-	payload := map[string]interface{}{
-		"email":    email,
-		"exp":      expirationTime,
+	if err := s.authenticateOptionalClient(ctx, clientID, clientSecret); err != nil {
+		return nil, err
 	}
-	payloadJson, _ := json.Marshal(payload)
-	payloadEncoded := base64.RawURLEncoding.EncodeToString(payloadJson)
 
-	dataToSign := headerEncoded + "." + payloadEncoded
+	user, err := s.authenticateUser(ctx, email, password, info)
+	if err != nil {
+		return nil, err
+	}
 
-	mac := hmac.New(sha256.New, secretKey)
-	mac.Write([]byte(dataToSign))
-	signature := mac.Sum(nil)
-	signatureEncoded := base64.RawURLEncoding.EncodeToString(signature)
+	familyID, err := generateRandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueUserTokens(ctx, userGrant{
+		grantType: "password",
+		userID:    user.ID,
+		email:     user.Email,
+		role:      user.Role,
+		scope:     scope,
+		clientID:  clientID,
+		familyID:  familyID,
+		info:      info,
+	})
+}
 
-	accessToken := dataToSign + "." + signatureEncoded
+// userScope normalizes the scope a user asks for without going through a
+// registered client. Only the OpenID Connect scopes are allowed.
+func userScope(scope string) (string, error) {
+	requested := strings.Fields(scope)
+	for _, entry := range requested {
+		if !slices.Contains(oidcScopes, entry) {
+			return "", ErrInvalidScope
+		}
+	}
+	return strings.Join(requested, " "), nil
+}
 
-	refreshToken, err := generateRandomToken(32)
+// userGrant describes what a user has been granted by one of the flows
+type userGrant struct {
+	grantType string
+	userID    int
+	email     string
+	role      string
+	scope     string
+	clientID  string
+	nonce     string
+	familyID  string
+	info      model.RequestInfo
+}
+
+// issueUserTokens stores a new refresh token in the grant's family and
+// returns it together with the access (and ID) token
+func (s *TokenService) issueUserTokens(ctx context.Context, grant userGrant) (*model.TokenResponse, error) {
+	refreshToken, row, err := newRefreshToken(grant)
 	if err != nil {
 		return nil, err
 	}
-	refreshTokenExpiresAt := time.Now().Add(30 * 24 * time.Hour)
 	// Create token
-	err = s.tokenRepo.CreateToken(ctx, email, clientSecret, refreshToken, refreshTokenExpiresAt)
+	if err := s.tokenRepo.CreateToken(ctx, row); err != nil {
+		return nil, err
+	}
+
+	auditTokenIssued(ctx, grant)
+	return newUserTokenResponse(grant, refreshToken)
+}
+
+// newRefreshToken generates a refresh token for grant and the row to store
+// for it
+func newRefreshToken(grant userGrant) (string, *model.RefreshToken, error) {
+	refreshToken, err := generateRandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	return refreshToken, &model.RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		UserID:    grant.userID,
+		FamilyID:  grant.familyID,
+		Scope:     grant.scope,
+		ClientID:  grant.clientID,
+		IP:        grant.info.IP,
+		UserAgent: grant.info.UserAgent,
+		ExpiresAt: refreshTokenExpiry(grant.clientID),
+	}, nil
+}
+
+func auditTokenIssued(ctx context.Context, grant userGrant) {
+	recordAudit(ctx, model.AuditEvent{
+		Type:      AuditTokenIssued,
+		Outcome:   AuditSuccess,
+		UserID:    grant.userID,
+		Email:     grant.email,
+		Actor:     grant.email,
+		ClientID:  grant.clientID,
+		IP:        grant.info.IP,
+		UserAgent: grant.info.UserAgent,
+		Detail:    "grant=" + grant.grantType + " session=" + grant.familyID,
+	})
+}
+
+func newUserTokenResponse(grant userGrant, refreshToken string) (*model.TokenResponse, error) {
+	policy := policyFor(grant.clientID)
+	accessToken, err := newAccessToken(model.AccessTokenClaims{
+		Subject:     strconv.Itoa(grant.userID),
+		Email:       grant.email,
+		ClientID:    grant.clientID,
+		Scope:       grant.scope,
+		SessionID:   grant.familyID,
+		Role:        grant.role,
+		Permissions: PermissionsFor(grant.role),
+	}, policy)
 	if err != nil {
 		return nil, err
 	}
-		
+
 	// Prepare response
 	response := &model.TokenResponse{
 		AccessToken: accessToken,
 		RefreshToken: refreshToken,
 		TokenType:   "Bearer",
+		ExpiresIn:   int64(policy.AccessTokenTTL.Seconds()),
+		Scope:       grant.scope,
+	}
+
+	if hasScope(grant.scope, "openid") {
+		response.IDToken, err = newIDToken(grant)
+		if err != nil {
+			return nil, err
+		}
 	}
 	
 	return response, nil
 }
 
+// authenticateUser verifies email and password and records the attempt in
+// the audit log. Passwords stored in an outdated form are upgraded to bcrypt
+// once they have been verified.
+func (s *TokenService) authenticateUser(ctx context.Context, email, password string, info model.RequestInfo) (*model.UserCredentials, error) {
+	event := model.AuditEvent{
+		Type:      AuditLogin,
+		Outcome:   AuditFailure,
+		Email:     email,
+		Actor:     email,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+	}
+	user, err := s.userRepo.GetCredentialsByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+			event.Detail = "unknown email"
+			recordAudit(ctx, event)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	event.UserID = user.ID
+	ok, needsRehash := verifyPassword(user, password)
+	if !ok {
+		event.Detail = "wrong password"
+		recordAudit(ctx, event)
+		return nil, ErrInvalidCredentials
+	}
+	event.Outcome = AuditSuccess
+	recordAudit(ctx, event)
+	if needsRehash {
+		hash, err := hashPassword(password)
+		if err == nil {
+			_, err = s.userRepo.UpdatePassword(ctx, user.ID, user.PasswordAlgo, hash, PasswordAlgoBcrypt)
+		}
+		if err != nil {
+			log.Printf("password rehash failed for user %d: %v", user.ID, err)
+		}
+	}
+	return user, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access/refresh pair.
+// The presented token is rotated out and cannot be used again. clientID has
+// to be the client the token was issued to, and a confidential client has to
+// authenticate with clientSecret (RFC 6749 section 6); a token issued to
+// another client gives ErrClientMismatch and stays valid.
+func (s *TokenService) RefreshToken(ctx context.Context, refreshToken, clientID, clientSecret string, info model.RequestInfo) (*model.TokenResponse, error) {
+	if err := s.authenticateOptionalClient(ctx, clientID, clientSecret); err != nil {
+		return nil, err
+	}
+	newRefreshToken, err := generateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	checkClient := func(rt *model.RefreshToken) error {
+		if rt.ClientID != clientID {
+			return ErrClientMismatch
+		}
+		return nil
+	}
+	rotated, err := s.tokenRepo.RotateRefreshToken(ctx, hashToken(refreshToken), hashToken(newRefreshToken), refreshTokenExpiry, checkClient, info)
+	if err != nil {
+		if !errors.Is(err, repository.ErrRefreshTokenNotFound) {
+			recordAudit(ctx, model.AuditEvent{
+				Type:      AuditTokenRefreshed,
+				Outcome:   AuditFailure,
+				IP:        info.IP,
+				UserAgent: info.UserAgent,
+				Detail:    err.Error(),
+			})
+		}
+		switch {
+		case errors.Is(err, repository.ErrRefreshTokenNotFound):
+			return nil, ErrTokenNotFound
+		case errors.Is(err, repository.ErrRefreshTokenExpired):
+			return nil, ErrTokenExpired
+		case errors.Is(err, repository.ErrRefreshTokenRevoked):
+			return nil, ErrTokenRevoked
+		case errors.Is(err, repository.ErrRefreshTokenReused):
+			return nil, ErrTokenReused
+		case errors.Is(err, ErrClientMismatch):
+			return nil, ErrClientMismatch
+		default:
+			return nil, err
+		}
+	}
+
+	recordAudit(ctx, model.AuditEvent{
+		Type:      AuditTokenRefreshed,
+		Outcome:   AuditSuccess,
+		UserID:    rotated.UserID,
+		Email:     rotated.Email,
+		Actor:     rotated.Email,
+		ClientID:  rotated.ClientID,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+		Detail:    "session=" + rotated.FamilyID,
+	})
+	return newUserTokenResponse(userGrant{
+		userID:   rotated.UserID,
+		email:    rotated.Email,
+		role:     rotated.Role,
+		scope:    rotated.Scope,
+		clientID: rotated.ClientID,
+		familyID: rotated.FamilyID,
+	}, newRefreshToken)
+}
+
+// ClientCredentials issues an access token to a registered client acting on
+// its own behalf. An empty scope grants every scope the client is allowed.
+// No refresh token is issued; clients simply authenticate again.
+func (s *TokenService) ClientCredentials(ctx context.Context, clientID, clientSecret, scope string, info model.RequestInfo) (*model.TokenResponse, error) {
+	client, err := s.clientRepo.GetClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrClientNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(clientSecret)) != nil {
+		recordAudit(ctx, model.AuditEvent{
+			Type:      AuditTokenIssued,
+			Outcome:   AuditFailure,
+			Actor:     "client:" + client.ClientID,
+			ClientID:  client.ClientID,
+			IP:        info.IP,
+			UserAgent: info.UserAgent,
+			Detail:    "grant=client_credentials wrong client secret",
+		})
+		return nil, ErrInvalidClient
+	}
+
+	grantedScope := strings.Join(client.Scopes, " ")
+	if scope != "" {
+		if grantedScope, err = allowedScope(client, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	policy := policyFor(client.ClientID)
+	accessToken, err := newAccessToken(model.AccessTokenClaims{
+		Subject:  client.ClientID,
+		ClientID: client.ClientID,
+		Scope:    grantedScope,
+	}, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	recordAudit(ctx, model.AuditEvent{
+		Type:      AuditTokenIssued,
+		Outcome:   AuditSuccess,
+		Actor:     "client:" + client.ClientID,
+		ClientID:  client.ClientID,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+		Detail:    "grant=client_credentials scope=" + grantedScope,
+	})
+
+	return &model.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(policy.AccessTokenTTL.Seconds()),
+		Scope:       grantedScope,
+	}, nil
+}
+
+// lookupClient returns the registered client clientID or ErrInvalidClient
+func (s *TokenService) lookupClient(ctx context.Context, clientID string) (*model.Client, error) {
+	client, err := s.clientRepo.GetClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrClientNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+// authenticateOptionalClient checks the client a user grant names, if any:
+// it must be registered, and a confidential one has to authenticate
+func (s *TokenService) authenticateOptionalClient(ctx context.Context, clientID, clientSecret string) error {
+	if clientID == "" {
+		return nil
+	}
+	client, err := s.lookupClient(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if !client.Public {
+		_, err = s.authenticateConfidentialClient(ctx, clientID, clientSecret)
+	}
+	return err
+}
+
+// authenticateConfidentialClient checks the secret of a registered client.
+// Public clients have no secret and cannot authenticate this way.
+func (s *TokenService) authenticateConfidentialClient(ctx context.Context, clientID, clientSecret string) (*model.Client, error) {
+	client, err := s.lookupClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public || bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(clientSecret)) != nil {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// allowedScope normalizes a requested scope string and checks every entry
+// against the scopes registered for the client
+func allowedScope(client *model.Client, scope string) (string, error) {
+	requested := strings.Fields(scope)
+	for _, entry := range requested {
+		if !slices.Contains(client.Scopes, entry) {
+			return "", ErrInvalidScope
+		}
+	}
+	return strings.Join(requested, " "), nil
+}
+
+// newAccessToken fills in the registered claims other than sub from policy,
+// generates a jti unless one is set and signs the token
+func newAccessToken(claims model.AccessTokenClaims, policy model.TokenPolicy) (string, error) {
+	if claims.ID == "" {
+		jti, err := generateRandomToken(16)
+		if err != nil {
+			return "", err
+		}
+		claims.ID = jti
+	}
+	now := time.Now()
+	claims.Issuer = issuer
+	claims.Audience = policy.Audience
+	claims.ExpiresAt = now.Add(policy.AccessTokenTTL).Unix()
+	claims.IssuedAt = now.Unix()
+	return signJWT(typAccessToken, claims)
+}
+
 func generateRandomToken(length int) (string, error) {
 	bytes := make([]byte, length)
 	_, err := rand.Read(bytes)
@@ -95,25 +472,126 @@ func generateRandomToken(length int) (string, error) {
 	return base64.RawURLEncoding.EncodeToString(bytes), nil
 }
 
-// ValidateToken validates an access token
-// func (s *TokenService) ValidateToken(ctx context.Context, tokenStr string) (*model.TokenValidationResponse, error) {
-// 	// Get token from repository
-// 	token, err := s.tokenRepo.GetToken(ctx, tokenStr)
-// 	if err != nil {
-// 		return nil, ErrTokenNotFound
-// 	}
-	
-// 	// Check token expiration
-// 	if time.Now().After(token.ExpirationTime) {
-// 		return nil, ErrTokenExpired
-// 	}
-	
-// 	// Prepare response
-// 	response := &model.TokenValidationResponse{
-// 		ClientID: token.ClientID,
-// 		Scope:    token.AccessScope,
-// 		Valid:    true,
-// 	}
-	
-// 	return response, nil
-// }
\ No newline at end of file
+// VerifyAccessToken checks the signature, expiry and issuer of an access
+// token and returns its claims. The token has to be meant for auth-service
+// itself. Tokens of a session that was logged out are revoked.
+func (s *TokenService) VerifyAccessToken(ctx context.Context, token string) (*model.AccessTokenClaims, error) {
+	return s.verifyAccessToken(ctx, token, issuer)
+}
+
+// verifyAccessToken is VerifyAccessToken for another audience; an empty
+// audience accepts tokens meant for any service
+func (s *TokenService) verifyAccessToken(ctx context.Context, token, audience string) (*model.AccessTokenClaims, error) {
+	var claims model.AccessTokenClaims
+	if err := parseJWT(token, typAccessToken, &claims); err != nil {
+		return nil, err
+	}
+	if err := checkRegisteredClaims(&claims, audience); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	if claims.ID != "" || claims.SessionID != "" {
+		revoked, err := s.tokenRepo.IsAccessTokenRevoked(ctx, claims.ID, claims.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return &claims, nil
+}
+
+// IntrospectionScope is the scope a client must be registered with to call
+// the introspection endpoint
+const IntrospectionScope = "introspect"
+
+// IntrospectToken reports the state of a token in the RFC 7662 format. The
+// caller has to authenticate as a confidential client registered with the
+// introspect scope (RFC 7662 section 2.1), so tokens cannot be probed by
+// anyone. The caller declares the audience it accepts tokens for, by default
+// its client_id; tokens meant for other audiences are reported as inactive,
+// like any token that fails verification, without detail.
+func (s *TokenService) IntrospectToken(ctx context.Context, clientID, clientSecret, token, audience string) (*model.IntrospectionResponse, error) {
+	client, err := s.authenticateConfidentialClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(client.Scopes, IntrospectionScope) {
+		return nil, ErrInvalidScope
+	}
+
+	if audience == "" {
+		audience = client.ClientID
+	}
+	claims, err := s.verifyAccessToken(ctx, token, audience)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrTokenRevoked) {
+			return &model.IntrospectionResponse{Active: false}, nil
+		}
+		return nil, err
+	}
+
+	return &model.IntrospectionResponse{
+		Active:      true,
+		Scope:       claims.Scope,
+		ClientID:    claims.ClientID,
+		Subject:     claims.Subject,
+		Audience:    claims.Audience,
+		Issuer:      claims.Issuer,
+		Email:       claims.Email,
+		Role:        claims.Role,
+		Permissions: claims.Permissions,
+		Actor:       claims.Actor,
+		TokenType:   "Bearer",
+		ExpiresAt:   claims.ExpiresAt,
+		IssuedAt:    claims.IssuedAt,
+	}, nil
+}
+
+
+// RevokeToken invalidates a refresh or access token (RFC 7009). hint is the
+// optional token_type_hint; when it is wrong the other type is tried.
+// Tokens that are unknown, malformed or already expired are ignored.
+func (s *TokenService) RevokeToken(ctx context.Context, token, hint string, info model.RequestInfo) error {
+	event := model.AuditEvent{
+		Type:      AuditTokenRevoked,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+	}
+	if hint != "refresh_token" {
+		var claims model.AccessTokenClaims
+		if err := parseJWT(token, typAccessToken, &claims); err == nil {
+			if claims.ID == "" || time.Now().Unix() >= claims.ExpiresAt {
+				return nil
+			}
+			err = s.tokenRepo.RevokeAccessToken(ctx, claims.ID, time.Unix(claims.ExpiresAt, 0))
+			event.UserID, _ = strconv.Atoi(claims.Subject)
+			event.Email = claims.Email
+			event.Actor = auditActor(&claims)
+			event.ClientID = claims.ClientID
+			event.Outcome = auditOutcome(err)
+			event.Detail = "access_token jti=" + claims.ID
+			recordAudit(ctx, event)
+			return err
+		}
+	}
+	// strings that are no token of ours are not worth an audit event
+	revoked, err := s.tokenRepo.RevokeRefreshToken(ctx, hashToken(token))
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	event.UserID = revoked.UserID
+	event.Email = revoked.Email
+	event.Actor = revoked.Email
+	event.ClientID = revoked.ClientID
+	event.Outcome = AuditSuccess
+	event.Detail = "refresh_token family=" + revoked.FamilyID
+	recordAudit(ctx, event)
+	return nil
+}
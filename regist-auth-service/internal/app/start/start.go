@@ -10,6 +10,7 @@ import (
 	"golangproject/internal/services/bet"
 	"golangproject/internal/services/session.go"
 	"golangproject/internal/services/user"
+	"golangproject/pkg/domain"
 
 	"github.com/gorilla/mux"
 	"github.com/swaggo/http-swagger"
@@ -63,11 +64,11 @@ func setupRouter(authService *auth.Service, userService *user.Service,
     loginHandler := http_delivery.LoginHandler(authService, userService, sessionService)
     logoutHandler := http_delivery.LogoutHandler(sessionService)
 	registerHandler := http_delivery.RegisterHandler(userService)
-    betHandler := authMiddleware(
+    betHandler := authMiddleware(http_delivery.RequireRole(domain.RolePlayer, domain.RoleAdmin)(
         http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
             http_delivery.BetHandler(w, r, betService)
         }),
-    )
+    ))
 
     // public routes
 	router.Path("/swagger/{any:.*}").Handler(httpSwagger.WrapHandler)
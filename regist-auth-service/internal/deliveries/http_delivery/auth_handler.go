@@ -189,10 +189,14 @@ func ProfileHandler(userService *user.Service) http.HandlerFunc {
             UUID     uuid.UUID    `json:"uuid"`
             Username string `json:"username"`
             Email    string `json:"email"`
+            Role        string   `json:"role"`
+            Permissions []string `json:"permissions"`
         }{
             UUID:       user.UUID,
             Username: user.Username,
             Email:    user.Email,
+            Role:        user.Role,
+            Permissions: domain.PermissionsFor(user.Role),
         }
 
         w.Header().Set("Content-Type", "application/json")
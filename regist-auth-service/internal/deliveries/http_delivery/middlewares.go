@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"slices"
 	"strings"
 	//"time"
 
@@ -42,8 +43,10 @@ func AuthMiddleware(authService *auth.Service, userService *user.Service) func(h
                 return
             }
 
-            // Inject user into context
+            // Inject user into context. The role comes from the database so
+            // a changed role applies to tokens that were already issued.
             ctx := context.WithValue(r.Context(), middleware.CurrentUserKey, userUUID)
+            ctx = context.WithValue(ctx, middleware.CurrentRoleKey, user.Role)
 
             // Call the next handler with the updated context
             next.ServeHTTP(w, r.WithContext(ctx))
@@ -51,6 +54,21 @@ func AuthMiddleware(authService *auth.Service, userService *user.Service) func(h
     }
 }
 
+// RequireRole lets a request through only when the user has one of roles.
+// It has to be wrapped by AuthMiddleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            role, _ := r.Context().Value(middleware.CurrentRoleKey).(string)
+            if !slices.Contains(roles, role) {
+                http.Error(w, "Forbidden", http.StatusForbidden)
+                return
+            }
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
 /* func SessionMiddleware(sessionService *session.Service) func(http.Handler) http.Handler {
     return func(next http.Handler) http.Handler {
         return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ADD COLUMN role ENUM('player', 'support', 'finance', 'admin') NOT NULL DEFAULT 'player' AFTER balance;
\ No newline at end of file
@@ -34,13 +34,14 @@ func NewUserRepository(db *sql.DB) UserRepository {
 
 func (r *userRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
 	//fmt.Println(id)
-    const query = `SELECT uuid, email, username FROM users WHERE uuid = UNHEX(REPLACE(?, "-", ""));`
+    const query = `SELECT uuid, email, username, role FROM users WHERE uuid = UNHEX(REPLACE(?, "-", ""));`
     
     var user domain.User
     err := r.db.QueryRowContext(ctx, query, id).Scan(
         &user.UUID,
         &user.Email,
         &user.Username,
+        &user.Role,
     )
     //fmt.Println(user.Username)
 	if err != nil {
@@ -58,7 +59,8 @@ func (r *userRepo) GetByEmail(ctx context.Context, email string) (*domain.User,
 		SELECT 
 			uuid, 
 			email, 
-			password
+			password,
+			role
 		FROM users 
 		WHERE email = ?`
 
@@ -68,6 +70,7 @@ func (r *userRepo) GetByEmail(ctx context.Context, email string) (*domain.User,
 		&user.UUID,
 		&user.Email,
 		&user.Password,
+		&user.Role,
 	)
 
 	switch {
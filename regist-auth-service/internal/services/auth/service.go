@@ -15,7 +15,9 @@ type Service struct {
 }
 
 type Claims struct {
-	UserUUID uuid.UUID `json:"user_uuid"`
+	UserUUID    uuid.UUID `json:"user_uuid"`
+	Role        string    `json:"role"`
+	Permissions []string  `json:"permissions"`
 	jwt.RegisteredClaims
 }
 
@@ -25,8 +27,10 @@ func New(jwtSecret string) *Service {
 
 func (s *Service) GenerateToken(user *domain.User) (string, error) {
     claims := jwt.MapClaims{
-        "user_uuid": user.UUID,
-        "exp":     time.Now().Add(time.Hour * 72).Unix(),
+        "user_uuid":   user.UUID,
+        "role":        user.Role,
+        "permissions": domain.PermissionsFor(user.Role),
+        "exp":         time.Now().Add(time.Hour * 72).Unix(),
     }
     
     token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -13,6 +13,7 @@ type ContextKey string
 
 //const UserIDKey ContextKey = "user_id"
 const CurrentUserKey ContextKey = "current_user"
+const CurrentRoleKey ContextKey = "current_role"
 
 func Auth(next func(context.Context) (interface{}, error)) func(context.Context) (interface{}, error) {
     return func(ctx context.Context) (interface{}, error) {
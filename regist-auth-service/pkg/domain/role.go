@@ -0,0 +1,41 @@
+package domain
+
+import "slices"
+
+// Roles a user can have, see users.role
+const (
+	RolePlayer  = "player"
+	RoleSupport = "support"
+	RoleFinance = "finance"
+	RoleAdmin   = "admin"
+)
+
+// Permissions carried in the permissions claim of issued tokens. They match
+// the ones auth-service issues for the same roles.
+const (
+	PermissionBetsPlace        = "bets:place"
+	PermissionBalanceWrite     = "balance:write"
+	PermissionProfileRead      = "profile:read"
+	PermissionUsersRead        = "users:read"
+	PermissionTransactionsRead = "transactions:read"
+	PermissionPayoutsWrite     = "payouts:write"
+	PermissionSessionsAdmin    = "sessions:admin"
+	PermissionUsersImpersonate = "users:impersonate"
+	PermissionAuditRead        = "audit:read"
+)
+
+var rolePermissions = map[string][]string{
+	RolePlayer:  {PermissionBetsPlace, PermissionBalanceWrite, PermissionProfileRead},
+	RoleSupport: {PermissionProfileRead, PermissionUsersRead, PermissionSessionsAdmin, PermissionUsersImpersonate},
+	RoleFinance: {PermissionProfileRead, PermissionUsersRead, PermissionTransactionsRead, PermissionPayoutsWrite},
+	RoleAdmin: {
+		PermissionBetsPlace, PermissionBalanceWrite, PermissionProfileRead, PermissionUsersRead,
+		PermissionTransactionsRead, PermissionPayoutsWrite, PermissionSessionsAdmin, PermissionUsersImpersonate,
+		PermissionAuditRead,
+	},
+}
+
+// PermissionsFor returns the permissions granted to role
+func PermissionsFor(role string) []string {
+	return slices.Clone(rolePermissions[role])
+}
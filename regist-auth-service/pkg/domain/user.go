@@ -13,6 +13,7 @@ type User struct {
 	Password string
 	Username     string
 	Balance int
+	Role     string
 	CreatedAt time.Time
 }
 
@@ -1,17 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"runtime"
 	"time"
 
-	"transervice/config"
-	"transervice/controller"
-	"transervice/middleware"
-	"transervice/repository"
-	"transervice/service"
+	"transervice/internal/app/config"
+	"transervice/internal/app/middleware"
+	"transervice/internal/deliveries/controller"
+	repository "transervice/internal/repositories"
+	"transervice/internal/services"
+	"transervice/internal/services/fakegateway"
 )
 
 func main() {
@@ -30,15 +32,41 @@ func main() {
 	db.SetConnMaxLifetime(5 * time.Minute)
 
 	balanceRepo := repository.NewPostgresBalanceRepository(db)
+	idempotencyRepo := repository.NewPostgresIdempotencyRepository(db)
 	// userRepo := repository.NewPostgresUserRepository(db)
 
-	balanceService := service.NewBalanceService(balanceRepo)
+	gatewayURL := cfg.PaymentGatewayURL
+	if gatewayURL == "" {
+		gatewayURL = service.DefaultPaymentGatewayURL
+	}
+	if cfg.PaymentGateway == "fake" {
+		fake, err := fakegateway.NewServer()
+		if err != nil {
+			log.Fatalf("Fake payment gateway: %v", err)
+		}
+		defer fake.Close()
+		if err := fake.ScriptFromString(cfg.FakeGatewayScript); err != nil {
+			log.Fatalf("Fake payment gateway: %v", err)
+		}
+		gatewayURL = fake.URL
+		log.Printf("Using fake payment gateway at %s", gatewayURL)
+	}
+	gateway := service.NewHTTPPaymentGateway(gatewayURL)
+
+	balanceService := service.NewBalanceService(balanceRepo, idempotencyRepo, gateway)
+
+	introspectionURL := cfg.AuthIntrospectionURL
+	if introspectionURL == "" {
+		introspectionURL = service.DefaultIntrospectionURL
+	}
+	introspector := service.NewTokenIntrospector(introspectionURL, cfg.AuthIssuer, cfg.AuthClientID, cfg.AuthClientSecret, cfg.AuthTokenAudience)
 
-	balanceController := controller.NewBalanceController(balanceService)
+	balanceController := controller.NewBalanceController(balanceService, introspector)
+	go balanceService.PurgeIdempotencyKeys(context.Background(), time.Hour)
 
 	server := &http.Server{
 		Addr:         ":" + cfg.Port,
-		Handler:      setupRoutes(balanceController),
+		Handler:      setupRoutes(balanceController, introspector),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  220 * time.Second,
@@ -49,12 +77,22 @@ func main() {
 
 }
 
-func setupRoutes(balanceController *controller.BalanceController) http.Handler {
+func setupRoutes(balanceController *controller.BalanceController, introspector *service.TokenIntrospector) http.Handler {
 	mux := http.NewServeMux()
 
-	mux.Handle("/dep/balance", middleware.Recover(middleware.Logger(http.HandlerFunc(balanceController.ReplenishmentRequest))))
-	mux.Handle("/dep/withdrawal", middleware.Recover(middleware.Logger(http.HandlerFunc(balanceController.WithdrawalRequest))))
-	mux.Handle("/dep/updateresults", middleware.Recover(middleware.Logger(http.HandlerFunc(balanceController.UpdateBalance))))
+	// money must not move on a token a support agent uses on behalf of a player
+	mux.Handle("/dep/balance", middleware.Recover(middleware.Logger(middleware.RejectImpersonation(introspector.Introspect, http.HandlerFunc(balanceController.ReplenishmentRequest)))))
+	mux.Handle("/dep/withdrawal", middleware.Recover(middleware.Logger(middleware.RejectImpersonation(introspector.Introspect, http.HandlerFunc(balanceController.WithdrawalRequest)))))
+	mux.Handle("/dep/transactions", middleware.Recover(middleware.Logger(http.HandlerFunc(balanceController.TransactionHistory))))
+	// payouts credit arbitrary users, so only the settlement job (a client
+	// with payouts:write), finance staff and admins may call it
+	mux.Handle("/dep/updateresults", middleware.Recover(middleware.Logger(middleware.RejectImpersonation(introspector.Introspect,
+		middleware.RequireRoleOrScope(introspector.Introspect, service.GetUserRole, "payouts:write", http.HandlerFunc(balanceController.UpdateBalance), "finance", "admin")))))
+	mux.Handle("/dep/ledger/check", middleware.Recover(middleware.Logger(middleware.RequireRole(service.GetUserRole, http.HandlerFunc(balanceController.CheckLedger), "finance", "admin"))))
+	mux.Handle("/dep/withdrawals/resolve", middleware.Recover(middleware.Logger(middleware.RejectImpersonation(introspector.Introspect,
+		middleware.RequireRole(service.GetUserRole, http.HandlerFunc(balanceController.ResolveWithdrawal), "finance", "admin")))))
+	mux.Handle("/dep/deposits/resolve", middleware.Recover(middleware.Logger(middleware.RejectImpersonation(introspector.Introspect,
+		middleware.RequireRole(service.GetUserRole, http.HandlerFunc(balanceController.ResolveDeposit), "finance", "admin")))))
 
 	return mux
 }
\ No newline at end of file
@@ -6,6 +6,22 @@ import "log"
 type Config struct {
 	Port 		 string
 	DatabaseURL  string
+
+	// PaymentGateway is "http" for the provider at PaymentGatewayURL or
+	// "fake" for an in-process fake answering as FakeGatewayScript says
+	PaymentGateway    string
+	PaymentGatewayURL string
+	FakeGatewayScript string
+
+	// AuthIntrospectionURL is the /introspect endpoint of auth-service, empty
+	// for the one in the cluster. It is called as client AuthClientID with
+	// AuthClientSecret and accepts tokens meant for AuthTokenAudience. Only
+	// tokens whose iss is AuthIssuer are sent there.
+	AuthIntrospectionURL string
+	AuthClientID         string
+	AuthClientSecret     string
+	AuthTokenAudience    string
+	AuthIssuer           string
 }
 
 func New() *Config {
@@ -20,8 +36,44 @@ func New() *Config {
 		log.Fatal("DATABASE_URL environment variable is required")
 	}
 
+	gateway := os.Getenv("PAYMENT_GATEWAY")
+	if gateway == "" {
+		gateway = "http"
+	}
+	if gateway != "http" && gateway != "fake" {
+		log.Fatalf("PAYMENT_GATEWAY must be http or fake, got %q", gateway)
+	}
+
+	clientID := os.Getenv("AUTH_CLIENT_ID")
+	if clientID == "" {
+		clientID = "transactions-service"
+	}
+	issuer := os.Getenv("AUTH_ISSUER")
+	if issuer == "" {
+		issuer = "http://golang.medhelper.xyz"
+	}
+	// auth-service gives tokens its issuer as audience unless told otherwise
+	audience := os.Getenv("AUTH_TOKEN_AUDIENCE")
+	if audience == "" {
+		audience = issuer
+	}
+	clientSecret := os.Getenv("AUTH_CLIENT_SECRET")
+	if clientSecret == "" {
+		log.Fatal("AUTH_CLIENT_SECRET environment variable is required")
+	}
+
 	return &Config{
 		Port:         port,
 		DatabaseURL:  dbURL,
+
+		PaymentGateway:    gateway,
+		PaymentGatewayURL: os.Getenv("PAYMENT_GATEWAY_URL"),
+		FakeGatewayScript: os.Getenv("FAKE_GATEWAY_SCRIPT"),
+
+		AuthIntrospectionURL: os.Getenv("AUTH_INTROSPECT_URL"),
+		AuthClientID:         clientID,
+		AuthClientSecret:     clientSecret,
+		AuthTokenAudience:    audience,
+		AuthIssuer:           issuer,
 	}
 }
\ No newline at end of file
@@ -0,0 +1,94 @@
+-- Double-entry ledger. Every movement of money is a journal entry whose
+-- postings sum to zero. Wallet accounts keep their balance cached, the house
+-- and payment clearing accounts are summed from their postings when needed.
+CREATE TABLE ledger_accounts (
+    id BIGSERIAL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('wallet', 'house', 'clearing')),
+    owner_uuid TEXT,
+    balance BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE journal_entries (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(32) NOT NULL,
+    reference TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE journal_postings (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES journal_entries(id),
+    account_id BIGINT NOT NULL REFERENCES ledger_accounts(id),
+    amount BIGINT NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX idx_journal_postings_entry ON journal_postings(entry_id);
+CREATE INDEX idx_journal_postings_account ON journal_postings(account_id);
+
+-- checked at commit so that all postings of an entry are in place
+CREATE FUNCTION journal_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM journal_postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % does not balance', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER journal_postings_balanced
+    AFTER INSERT ON journal_postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION journal_entry_balanced();
+
+CREATE FUNCTION journal_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER journal_entries_immutable
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON journal_entries
+    FOR EACH STATEMENT EXECUTE FUNCTION journal_immutable();
+
+CREATE TRIGGER journal_postings_immutable
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON journal_postings
+    FOR EACH STATEMENT EXECUTE FUNCTION journal_immutable();
+
+INSERT INTO ledger_accounts (code, kind) VALUES ('house', 'house'), ('payment_clearing', 'clearing');
+
+-- carry the existing balances over, booked against the house
+DO $$
+DECLARE
+    opening BIGINT;
+BEGIN
+    INSERT INTO ledger_accounts (code, kind, owner_uuid, balance)
+    SELECT 'wallet:' || uuid, 'wallet', uuid, SUM(COALESCE(balance, 0))
+    FROM users
+    WHERE uuid IS NOT NULL
+    GROUP BY uuid;
+
+    IF EXISTS (SELECT 1 FROM ledger_accounts WHERE kind = 'wallet' AND balance <> 0) THEN
+        INSERT INTO journal_entries (kind, reference)
+        VALUES ('opening_balance', 'users.balance')
+        RETURNING id INTO opening;
+
+        INSERT INTO journal_postings (entry_id, account_id, amount)
+        SELECT opening, id, balance FROM ledger_accounts WHERE kind = 'wallet' AND balance <> 0;
+
+        INSERT INTO journal_postings (entry_id, account_id, amount)
+        SELECT opening, id, -(SELECT SUM(balance) FROM ledger_accounts WHERE kind = 'wallet')
+        FROM ledger_accounts
+        WHERE code = 'house' AND (SELECT SUM(balance) FROM ledger_accounts WHERE kind = 'wallet') <> 0;
+    END IF;
+END
+$$;
+
+-- existing negative balances are left alone, new postings may not overdraw
+ALTER TABLE ledger_accounts
+    ADD CONSTRAINT ledger_accounts_wallet_not_negative CHECK (kind <> 'wallet' OR balance >= 0) NOT VALID;
+
+ALTER TABLE transactions ADD COLUMN entry_id BIGINT REFERENCES journal_entries(id);
+ALTER TABLE transactions DROP CONSTRAINT transactions_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_type_check CHECK (type IN ('deposit', 'withdrawal', 'payout'));
@@ -0,0 +1,12 @@
+-- Responses of money moving requests, replayed when a client retries with
+-- the same Idempotency-Key. A row without completed_at is still in flight.
+CREATE TABLE idempotency_keys (
+    scope TEXT NOT NULL,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code INT,
+    response_body BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    completed_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (scope, key)
+);
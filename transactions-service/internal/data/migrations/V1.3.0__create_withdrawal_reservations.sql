@@ -0,0 +1,17 @@
+-- Withdrawals move the money out of the wallet into withdrawal_holds before
+-- the payment provider is called, and from there either on to the payment
+-- clearing account or back to the wallet.
+INSERT INTO ledger_accounts (code, kind) VALUES ('withdrawal_holds', 'clearing');
+
+CREATE TABLE withdrawal_reservations (
+    id BIGSERIAL PRIMARY KEY,
+    uuid TEXT NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'settled', 'released')),
+    hold_entry_id BIGINT NOT NULL REFERENCES journal_entries(id),
+    resolve_entry_id BIGINT REFERENCES journal_entries(id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_withdrawal_reservations_pending ON withdrawal_reservations(created_at) WHERE status = 'pending';
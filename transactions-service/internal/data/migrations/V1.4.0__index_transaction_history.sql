@@ -0,0 +1,4 @@
+-- transaction history is read per user, newest first, with the wallet
+-- balance after each entry summed from the postings
+CREATE INDEX idx_transactions_uuid_id ON transactions(uuid, id);
+CREATE INDEX idx_journal_postings_account_entry ON journal_postings(account_id, entry_id);
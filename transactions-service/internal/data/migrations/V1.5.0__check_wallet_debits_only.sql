@@ -0,0 +1,5 @@
+-- Wallets carried over with a negative balance must still take deposits and
+-- payouts. The CHECK rejected any update leaving such a wallet below zero,
+-- so the non-negative rule now applies to debits only and is enforced where
+-- postings are applied.
+ALTER TABLE ledger_accounts DROP CONSTRAINT ledger_accounts_wallet_not_negative;
@@ -0,0 +1,7 @@
+-- A claim holds its key for a lease only, so a request that died without
+-- answering does not block retries forever. Completed keys are kept for a
+-- retention period and then deleted.
+ALTER TABLE idempotency_keys ADD COLUMN claimed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+UPDATE idempotency_keys SET claimed_at = created_at;
+
+CREATE INDEX idx_idempotency_keys_last_used ON idempotency_keys((COALESCE(completed_at, claimed_at)));
@@ -0,0 +1,19 @@
+-- Card deposits are booked from payment clearing into deposit_holds before
+-- the card is charged, and from there on to the wallet once the provider
+-- accepts the charge, or back to payment clearing if it refuses. A deposit
+-- the provider did not answer for, or whose credit failed, stays pending
+-- until finance resolves it.
+INSERT INTO ledger_accounts (code, kind) VALUES ('deposit_holds', 'clearing');
+
+CREATE TABLE deposit_reservations (
+    id BIGSERIAL PRIMARY KEY,
+    uuid TEXT NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'credited', 'released')),
+    hold_entry_id BIGINT NOT NULL REFERENCES journal_entries(id),
+    resolve_entry_id BIGINT REFERENCES journal_entries(id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_deposit_reservations_pending ON deposit_reservations(created_at) WHERE status = 'pending';
@@ -1,5 +1,113 @@
 package model
 
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
 type Response struct {
 	Message string `json:"message"`
-}
\ No newline at end of file
+}
+
+// Ledger accounts that are not wallets
+const (
+	AccountHouse           = "house"
+	AccountPaymentClearing = "payment_clearing"
+	AccountWithdrawalHolds = "withdrawal_holds"
+	AccountDepositHolds    = "deposit_holds"
+)
+
+// WalletAccount is the ledger account holding the balance of a user
+func WalletAccount(uuid string) string {
+	return "wallet:" + uuid
+}
+
+// Posting moves Amount into (positive) or out of (negative) an account
+type Posting struct {
+	Account string
+	Amount  int64
+}
+
+// JournalEntry is one balanced movement of money: its postings sum to zero
+type JournalEntry struct {
+	Kind      string
+	Reference string
+	Postings  []Posting
+}
+
+// LedgerMismatch is a wallet whose cached balance disagrees with its postings
+// or with users.balance
+type LedgerMismatch struct {
+	Account     string `json:"account"`
+	Cached      int64  `json:"cached"`
+	Posted      int64  `json:"posted"`
+	UserBalance int64  `json:"user_balance"`
+}
+
+// PendingWithdrawal is a withdrawal reservation still waiting for the
+// provider's outcome
+type PendingWithdrawal struct {
+	ID        int64     `json:"id"`
+	UserID    string    `json:"user_id"`
+	Amount    int64     `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PendingDeposit is a card deposit whose charge outcome is unknown or whose
+// credit to the wallet failed
+type PendingDeposit struct {
+	ID        int64     `json:"id"`
+	UserID    string    `json:"user_id"`
+	Amount    int64     `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StoredResponse is the first response given for an idempotency key
+type StoredResponse struct {
+	StatusCode int
+	Body       []byte
+}
+
+// Transaction is one row of a user's transaction history. BalanceAfter is
+// the wallet balance once the transaction was booked, nil for transactions
+// from before the ledger.
+type Transaction struct {
+	ID           int64     `json:"id"`
+	Type         string    `json:"type"`
+	Amount       int64     `json:"amount"`
+	Time         time.Time `json:"time"`
+	BalanceAfter *int64    `json:"balance_after"`
+}
+
+// TransactionFilter selects a page of a user's transactions, newest first.
+// Zero values do not filter.
+type TransactionFilter struct {
+	UserID string
+	Type   string
+	From   time.Time
+	To     time.Time
+	// Before is the cursor: only transactions with a smaller id are returned
+	Before int64
+	Limit  int
+}
+
+// TokenInfo is what auth-service reports about a token through introspection.
+// Actor is set when a staff member acts on behalf of the user.
+type TokenInfo struct {
+	Active   bool        `json:"active"`
+	Scope    string      `json:"scope"`
+	ClientID string      `json:"client_id"`
+	Subject  string      `json:"sub"`
+	Actor    *TokenActor `json:"act"`
+}
+
+// TokenActor is the act claim of RFC 8693
+type TokenActor struct {
+	Subject string `json:"sub"`
+}
+
+// HasScope reports whether scope is one of the token's space separated scopes
+func (t *TokenInfo) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(t.Scope), scope)
+}
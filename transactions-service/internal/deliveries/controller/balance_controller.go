@@ -8,29 +8,53 @@ import (
   "strconv"
   "errors"
   "log"
-  "transervice/service"
+  "strings"
+  "transervice/internal/data/model"
+  "transervice/internal/services"
 )
 
 type BalanceController struct {
 	balanceService *service.BalanceService
+	introspector   *service.TokenIntrospector
 }
 var (
     ErrUserNotFound      = errors.New("user not found")
     ErrInsufficientFunds = errors.New("insufficient funds")
 )
-func NewBalanceController(balanceService *service.BalanceService) *BalanceController {
+func NewBalanceController(balanceService *service.BalanceService, introspector *service.TokenIntrospector) *BalanceController {
 	return &BalanceController{
 		balanceService: balanceService,
+		introspector:   introspector,
 	}
 }
 
+// rejectImpersonation answers 403 when accessToken, taken from the form, is
+// one a support agent uses on behalf of a player, as
+// middleware.RejectImpersonation does for the header. It reports whether the
+// response has been written.
+func (c *BalanceController) rejectImpersonation(ctx context.Context, w http.ResponseWriter, accessToken string) bool {
+	info, err := c.introspector.Introspect(ctx, accessToken)
+	if err != nil {
+		log.Printf("token introspection failed: %v", err)
+		respondWithError(w, "Authorization service unavailable", http.StatusServiceUnavailable)
+		return true
+	}
+	if info.Active && info.Actor != nil {
+		log.Printf("impersonated token rejected: actor=%s subject=%s", info.Actor.Subject, info.Subject)
+		respondWithError(w, "Not allowed while impersonating a user", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
 func (c *BalanceController) ReplenishmentRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	
-	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	// the card is charged within this deadline
+	ctx, cancel := context.WithTimeout(r.Context(), 16*time.Second)
 	defer cancel()
 	
 	if err := r.ParseForm(); err != nil {
@@ -54,17 +78,38 @@ func (c *BalanceController) ReplenishmentRequest(w http.ResponseWriter, r *http.
 		respondWithError(w, "Invalid money value", http.StatusBadRequest)
 		return
 	}
+	if c.rejectImpersonation(ctx, w, accesstoken) {
+		return
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	scope, ok := playerScope(w, "deposit", accesstoken, key)
+	if !ok {
+		return
+	}
+	w, finish, ok := c.beginIdempotent(ctx, w, scope, key, requestFingerprint(strconv.Itoa(moneyInt), lastDigits(cardnumber), cardowner))
+	if !ok {
+		return
+	}
+	defer finish()
 	
 	response, err := c.balanceService.Replenishment(ctx, accesstoken, cardnumber, cardowner, cvv, moneyInt)
 	if err != nil {
 		log.Printf("ReplenishmentRequest error: %v", err)
-		switch err {
-		case service.ErrNotEnoughMoney:
+		switch {
+		case errors.Is(err, service.ErrInvalidAmount):
+			respondWithError(w, "Amount must be positive", http.StatusBadRequest)
+		case errors.Is(err, service.ErrNotEnoughMoney):
 			respondWithError(w, "Not enough money on the card", http.StatusUnauthorized)
-		case service.ErrInvalidCredentials:
+		case errors.Is(err, service.ErrInvalidCredentialsCard):
 			respondWithError(w, "Invalid card credentials", http.StatusUnauthorized)
-		case service.ErrInvalidCredentials:
+		case errors.Is(err, service.ErrInvalidCredentials):
 			respondWithError(w, "Invalid user credentials", http.StatusUnauthorized)
+		case errors.Is(err, service.ErrPaymentFailed), errors.Is(err, service.ErrGatewayUnreachable):
+			respondWithError(w, "Payment provider error", http.StatusBadGateway)
+		case errors.Is(err, service.ErrNoMoneyMoved):
+			allowRetry(w)
+			respondWithError(w, "Internal server error", http.StatusInternalServerError)
 		default:
 			respondWithError(w, "Internal server error", http.StatusInternalServerError)
 		}
@@ -102,15 +147,41 @@ func (c *BalanceController) WithdrawalRequest(w http.ResponseWriter, r *http.Req
 		respondWithError(w, "Invalid money value", http.StatusBadRequest)
 		return
 	}
+	if c.rejectImpersonation(ctx, w, accesstoken) {
+		return
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	scope, ok := playerScope(w, "withdrawal", accesstoken, key)
+	if !ok {
+		return
+	}
+	w, finish, ok := c.beginIdempotent(ctx, w, scope, key, requestFingerprint(strconv.Itoa(moneyInt), lastDigits(cardnumber)))
+	if !ok {
+		return
+	}
+	defer finish()
 	
 	response, err := c.balanceService.Withdrawal(ctx, accesstoken, cardnumber, moneyInt)
 	if err != nil {
-		log.Printf("ReplenishmentRequest error: %v", err)
-		switch err {
-		case service.ErrNotEnoughMoney:
-			respondWithError(w, "Not enough money on the card", http.StatusUnauthorized)
-		case service.ErrInvalidCredentials:
-			respondWithError(w, "Invalid card credentials", http.StatusUnauthorized)
+		log.Printf("WithdrawalRequest error: %v", err)
+		switch {
+		case errors.Is(err, service.ErrInvalidAmount):
+			respondWithError(w, "Amount must be positive", http.StatusBadRequest)
+		case errors.Is(err, service.ErrInsufficientFunds):
+			respondWithError(w, "Not enough money in the wallet", http.StatusConflict)
+		// refusals by the provider are about the card, not the caller's token
+		case errors.Is(err, service.ErrNotEnoughMoney):
+			respondWithError(w, "Payout refused by the payment provider", http.StatusUnprocessableEntity)
+		case errors.Is(err, service.ErrInvalidCredentialsCard):
+			respondWithError(w, "Invalid card credentials", http.StatusUnprocessableEntity)
+		case errors.Is(err, service.ErrInvalidCredentials):
+			respondWithError(w, "Invalid user credentials", http.StatusUnauthorized)
+		case errors.Is(err, service.ErrPaymentFailed), errors.Is(err, service.ErrGatewayUnreachable):
+			respondWithError(w, "Payment provider error", http.StatusBadGateway)
+		case errors.Is(err, service.ErrNoMoneyMoved):
+			allowRetry(w)
+			respondWithError(w, "Internal server error", http.StatusInternalServerError)
 		default:
 			respondWithError(w, "Internal server error", http.StatusInternalServerError)
 		}
@@ -143,8 +214,9 @@ func (c *BalanceController) UpdateBalance(w http.ResponseWriter, r *http.Request
 	defer cancel()
 	
 	var payoutRequest struct {
-		UserID string  `json:"userId"`
-		Amount int `json:"amount"`
+		UserID   string `json:"userId"`
+		Amount   int    `json:"amount"`
+		PayoutID string `json:"payoutId"`
 	}
 	
 	decoder := json.NewDecoder(r.Body)
@@ -153,16 +225,35 @@ func (c *BalanceController) UpdateBalance(w http.ResponseWriter, r *http.Request
 		return
 	}
 	defer r.Body.Close()
-	
+
+	// the settlement job may identify a payout in the body instead of the header
+	key := r.Header.Get("Idempotency-Key")
+	if payoutRequest.PayoutID != "" {
+		if key != "" && key != payoutRequest.PayoutID {
+			respondWithErrorr(w, "Idempotency-Key and payoutId differ", http.StatusBadRequest)
+			return
+		}
+		key = payoutRequest.PayoutID
+	}
+	w, finish, ok := c.beginIdempotent(ctx, w, "payout", key, requestFingerprint(payoutRequest.UserID, strconv.Itoa(payoutRequest.Amount)))
+	if !ok {
+		return
+	}
+	defer finish()
 	
 	err := c.balanceService.ProcessUserPayout(ctx, payoutRequest.UserID, payoutRequest.Amount)
 	if err != nil {
 		log.Printf("ProcessUserPayout error: %v", err)
 		switch {
+		case errors.Is(err, service.ErrInvalidAmount):
+			respondWithErrorr(w, "Amount must be positive", http.StatusBadRequest)
 		case errors.Is(err, ErrUserNotFound):
 			respondWithErrorr(w, "User not found", http.StatusNotFound)
 		case errors.Is(err, ErrInsufficientFunds):
 			respondWithErrorr(w, "Insufficient system funds", http.StatusConflict)
+		case errors.Is(err, service.ErrNoMoneyMoved):
+			allowRetry(w)
+			respondWithErrorr(w, "Internal server error", http.StatusInternalServerError)
 		default:
 			respondWithErrorr(w, "Internal server error", http.StatusInternalServerError)
 		}
@@ -197,4 +288,207 @@ func respondWithJSONN(w http.ResponseWriter, data interface{}, statusCode int) {
 	if err := json.NewEncoder(w).Encode(data); err != nil {
 		log.Printf("Error encoding response: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+// CheckLedger compares every wallet with its postings and users.balance and
+// lists the ones that disagree
+func (c *BalanceController) CheckLedger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 16*time.Second)
+	defer cancel()
+
+	age := service.DefaultPendingWithdrawalAge
+	if value := r.URL.Query().Get("pending_minutes"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 0 {
+			respondWithError(w, "Invalid pending_minutes value", http.StatusBadRequest)
+			return
+		}
+		age = time.Duration(minutes) * time.Minute
+	}
+
+	mismatches, err := c.balanceService.CheckLedger(ctx)
+	if err != nil {
+		log.Printf("CheckLedger error: %v", err)
+		respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	pending, err := c.balanceService.PendingWithdrawals(ctx, age)
+	if err != nil {
+		log.Printf("PendingWithdrawals error: %v", err)
+		respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	deposits, err := c.balanceService.PendingDeposits(ctx, age)
+	if err != nil {
+		log.Printf("PendingDeposits error: %v", err)
+		respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, map[string]interface{}{
+		"consistent":          len(mismatches) == 0,
+		"mismatches":          mismatches,
+		"pending_withdrawals": pending,
+		"pending_deposits":    deposits,
+	}, http.StatusOK)
+}
+
+// ResolveWithdrawal settles or releases a pending withdrawal after finance
+// staff have checked its outcome with the payment provider
+func (c *BalanceController) ResolveWithdrawal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var request struct {
+		ID     int64  `json:"id"`
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ID <= 0 {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := c.balanceService.ResolvePendingWithdrawal(ctx, request.ID, request.Action)
+	if err != nil {
+		log.Printf("ResolveWithdrawal error: %v", err)
+		switch {
+		case errors.Is(err, service.ErrInvalidWithdrawalAction):
+			respondWithError(w, "Action must be settle or release", http.StatusBadRequest)
+		case errors.Is(err, service.ErrWithdrawalNotPending):
+			respondWithError(w, "Withdrawal is not pending", http.StatusConflict)
+		default:
+			respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	status := "settled"
+	if request.Action == "release" {
+		status = "released"
+	}
+	respondWithJSON(w, map[string]interface{}{"id": request.ID, "status": status}, http.StatusOK)
+}
+
+// ResolveDeposit credits or releases a pending deposit after finance staff
+// have checked with the payment provider whether the card was charged
+func (c *BalanceController) ResolveDeposit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var request struct {
+		ID     int64  `json:"id"`
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ID <= 0 {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := c.balanceService.ResolvePendingDeposit(ctx, request.ID, request.Action)
+	if err != nil {
+		log.Printf("ResolveDeposit error: %v", err)
+		switch {
+		case errors.Is(err, service.ErrInvalidDepositAction):
+			respondWithError(w, "Action must be credit or release", http.StatusBadRequest)
+		case errors.Is(err, service.ErrDepositNotPending):
+			respondWithError(w, "Deposit is not pending", http.StatusConflict)
+		default:
+			respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	status := "credited"
+	if request.Action == "release" {
+		status = "released"
+	}
+	respondWithJSON(w, map[string]interface{}{"id": request.ID, "status": status}, http.StatusOK)
+}
+
+// TransactionHistory lists the transactions of the caller, newest first.
+// Support staff may pass user_id to look at another user. Pages are chained
+// through the before cursor returned as next_before.
+func (c *BalanceController) TransactionHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	authHeader := r.Header.Get("Authorization")
+	accesstoken := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	if !strings.HasPrefix(authHeader, "Bearer ") || accesstoken == "" {
+		respondWithError(w, "Authorization header missing or invalid", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := model.TransactionFilter{
+		UserID: query.Get("user_id"),
+		Type:   query.Get("type"),
+	}
+	var err error
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			if *target, err = time.Parse(time.RFC3339, value); err != nil {
+				respondWithError(w, "Invalid "+name+" value, expected RFC 3339", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if value := query.Get("before"); value != "" {
+		if filter.Before, err = strconv.ParseInt(value, 10, 64); err != nil || filter.Before <= 0 {
+			respondWithError(w, "Invalid before value", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			respondWithError(w, "Invalid limit value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	transactions, err := c.balanceService.TransactionHistory(ctx, accesstoken, filter)
+	if err != nil {
+		log.Printf("TransactionHistory error: %v", err)
+		switch err {
+		case service.ErrInvalidTransactionType:
+			respondWithError(w, "Invalid type, expected deposit, withdrawal or payout", http.StatusBadRequest)
+		case service.ErrInvalidCredentials:
+			respondWithError(w, "Invalid user credentials", http.StatusUnauthorized)
+		case service.ErrForbidden:
+			respondWithError(w, "Forbidden", http.StatusForbidden)
+		default:
+			respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	response := map[string]interface{}{"transactions": transactions}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = service.DefaultTransactionPage
+	}
+	if len(transactions) == min(limit, service.MaxTransactionPage) {
+		response["next_before"] = transactions[len(transactions)-1].ID
+	}
+	respondWithJSON(w, response, http.StatusOK)
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+
+	"transervice/internal/data/model"
+	"transervice/internal/services"
+)
+
+// recordingWriter passes the response through and keeps a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+	// retryable marks a failure that moved no money
+	retryable bool
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.statusCode == 0 {
+		w.statusCode = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// allowRetry marks the response written to w as a failure that moved no
+// money, so that its idempotency key is released instead of replaying it
+func allowRetry(w http.ResponseWriter) {
+	if rec, ok := w.(*recordingWriter); ok {
+		rec.retryable = true
+	}
+}
+
+// requestFingerprint hashes the fields that make up a request, so that a key
+// reused for another request can be told apart without storing card data
+func requestFingerprint(fields ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// lastDigits keeps only the tail of a card number for fingerprinting
+func lastDigits(cardNumber string) string {
+	if len(cardNumber) <= 4 {
+		return cardNumber
+	}
+	return cardNumber[len(cardNumber)-4:]
+}
+
+// beginIdempotent claims key for the request. When it returns false the
+// response has already been written: a replay of the first response or an
+// error. Otherwise the handler must write its response to the returned writer
+// and call finish once done. Only final outcomes are stored: a failure marked
+// with allowRetry releases the key. An empty key turns all of this off.
+func (c *BalanceController) beginIdempotent(ctx context.Context, w http.ResponseWriter, scope, key, fingerprint string) (http.ResponseWriter, func(), bool) {
+	if key == "" {
+		return w, func() {}, true
+	}
+	if len(key) > service.MaxIdempotencyKeyLength {
+		respondWithError(w, "Idempotency key is too long", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	stored, err := c.balanceService.ClaimIdempotencyKey(ctx, scope, key, fingerprint)
+	switch err {
+	case nil:
+	case service.ErrIdempotencyKeyReused:
+		respondWithError(w, "Idempotency key was already used for a different request", http.StatusUnprocessableEntity)
+		return nil, nil, false
+	case service.ErrIdempotencyKeyInProgress:
+		respondWithError(w, "A request with this idempotency key is still being processed", http.StatusConflict)
+		return nil, nil, false
+	default:
+		log.Printf("Idempotency key claim error: %v", err)
+		respondWithError(w, "Internal server error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	if stored != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.StatusCode)
+		w.Write(stored.Body)
+		return nil, nil, false
+	}
+
+	rec := &recordingWriter{ResponseWriter: w}
+	finish := func() {
+		var response *model.StoredResponse
+		// nothing written means the handler panicked, let the client retry
+		if rec.statusCode != 0 && !rec.retryable {
+			response = &model.StoredResponse{StatusCode: rec.statusCode, Body: rec.body.Bytes()}
+		}
+		// the request context may be done by now, the key must be completed
+		// regardless or a retry would move the money again
+		if err := c.balanceService.CompleteIdempotencyKey(context.WithoutCancel(ctx), scope, key, response); err != nil {
+			log.Printf("Idempotency key %q in %s not completed: %v", key, scope, err)
+		}
+	}
+	return rec, finish, true
+}
+
+// playerScope keeps the idempotency keys of each user apart, so that one
+// user cannot replay or block the requests of another
+func playerScope(w http.ResponseWriter, kind, accessToken, key string) (string, bool) {
+	if key == "" {
+		return "", true
+	}
+	uuid, err := service.GetUserUUID(accessToken)
+	if err != nil {
+		log.Printf("ERROR: Failed to get user UUID, invalid token: %v", err)
+		respondWithError(w, "Invalid user credentials", http.StatusUnauthorized)
+		return "", false
+	}
+	return kind + ":" + uuid, true
+}
@@ -4,7 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"strings"
-	"fmt"
+
+	"transervice/internal/data/model"
 )
 
 type PostgresBalanceRepository struct {
@@ -15,119 +16,36 @@ func NewPostgresBalanceRepository(db *sql.DB) BalanceRepository {
 	return &PostgresBalanceRepository{db: db}
 }
 
-func (r *PostgresBalanceRepository) UpdateBalanceByUUID(ctx context.Context, uuid string, amount int) error {
+// cleanUUID turns a profile uuid into the form users are stored under
+func cleanUUID(uuid string) string {
 	upperUUID := strings.ToUpper(uuid)
 	noHyphens := strings.ReplaceAll(upperUUID, "-", "")
-	cleaned := "0x" + noHyphens
-	
-	query := `
-		UPDATE users
-		SET balance = balance + $1
-		WHERE uuid = $2
-	`
-	result, err := r.db.ExecContext(ctx, query, amount, cleaned)
-	if err != nil {
-		return err
-	}
-	
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	
-	if rows == 0 {
-		insertQuery := `
-			INSERT INTO users (uuid, balance)
-			VALUES ($1, $2)
-		`
-		_, err = r.db.ExecContext(ctx, insertQuery, cleaned, amount)
-		if err != nil {
-			return err
-		}
-	}
-	
-	return nil
+	return "0x" + noHyphens
 }
 
-func (r *PostgresBalanceRepository) UpdateBalanceByUUIDPAY(ctx context.Context, uuid string, amount int) error {
-	query := `
-		UPDATE users
-		SET balance = balance + $1
-		WHERE uuid = $2
-	`
-	result, err := r.db.ExecContext(ctx, query, amount, uuid)
-	if err != nil {
-		return err
-	}
-	
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	
-	if rows == 0 {
-		insertQuery := `
-			INSERT INTO users (uuid, balance)
-			VALUES ($1, $2)
-		`
-		_, err = r.db.ExecContext(ctx, insertQuery, uuid, amount)
-		if err != nil {
-			return err
-		}
-	}
-	
-	return nil
-}
-
-
-func (r *PostgresBalanceRepository) UpdateBalanceByUUIDWithDrawal(ctx context.Context, uuid string, amount int) error {
-	upperUUID := strings.ToUpper(uuid)
-	noHyphens := strings.ReplaceAll(upperUUID, "-", "")
-	cleaned := "0x" + noHyphens
-	query := `
-		UPDATE users
-		SET balance = balance - $1
-		WHERE uuid = $2
-	`
-	_, err := r.db.ExecContext(ctx, query, amount, cleaned)
-	return err
+// UpdateBalanceByUUID books a card deposit: money arrives through the payment
+// clearing account into the wallet
+func (r *PostgresBalanceRepository) UpdateBalanceByUUID(ctx context.Context, uuid string, amount int) error {
+	wallet := model.WalletAccount(cleanUUID(uuid))
+	return r.postTransaction(ctx, uuid, amount, "deposit", model.JournalEntry{
+		Kind:      "deposit",
+		Reference: uuid,
+		Postings: []model.Posting{
+			{Account: model.AccountPaymentClearing, Amount: -int64(amount)},
+			{Account: wallet, Amount: int64(amount)},
+		},
+	})
 }
 
-func (r *PostgresBalanceRepository) IsThereEnoughMoneyByUUID(ctx context.Context, uuid string, amount int) (bool, error) {
-	upperUUID := strings.ToUpper(uuid)
-	noHyphens := strings.ReplaceAll(upperUUID, "-", "")
-	cleaned := "0x" + noHyphens
-	query := `
-		SELECT balance
-		FROM users
-		WHERE uuid = $1
-	`
-	var balance int
-	err := r.db.QueryRowContext(ctx, query, cleaned).Scan(&balance)
-	if err == sql.ErrNoRows {
-		return false, fmt.Errorf("user with uuid %s not found", uuid)
-	}
-	if err != nil {
-		return false, err
-	}
-
-	return balance >= amount, nil
+// UpdateBalanceByUUIDPAY books a game payout from the house to the wallet
+func (r *PostgresBalanceRepository) UpdateBalanceByUUIDPAY(ctx context.Context, uuid string, amount int) error {
+	wallet := model.WalletAccount(uuid)
+	return r.postTransaction(ctx, uuid, amount, "payout", model.JournalEntry{
+		Kind:      "payout",
+		Reference: uuid,
+		Postings: []model.Posting{
+			{Account: model.AccountHouse, Amount: -int64(amount)},
+			{Account: wallet, Amount: int64(amount)},
+		},
+	})
 }
-
-func (r *PostgresBalanceRepository) TransactionCreate(ctx context.Context, uuid string, amount int, transactionType string) error {
-    query := `
-        INSERT INTO transactions (uuid, amount, type) 
-        VALUES ($1, $2, $3)
-    `
-    
-    if transactionType != "deposit" && transactionType != "withdrawal" {
-        return sql.ErrNoRows 
-    }
-    
-    _, err := r.db.ExecContext(ctx, query, uuid, amount, transactionType)
-    if err != nil {
-        return err
-    }
-    
-    return nil
-}
\ No newline at end of file
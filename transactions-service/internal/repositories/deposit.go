@@ -0,0 +1,143 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"transervice/internal/data/model"
+)
+
+// ReserveDeposit books amount from payment clearing into deposit_holds, so
+// that a charge is on record before the provider is asked to make it
+func (r *PostgresBalanceRepository) ReserveDeposit(ctx context.Context, uuid string, amount int) (int64, error) {
+	if amount <= 0 {
+		return 0, ErrInvalidAmount
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	entryID, err := postEntry(ctx, tx, model.JournalEntry{
+		Kind:      "deposit_hold",
+		Reference: uuid,
+		Postings: []model.Posting{
+			{Account: model.AccountPaymentClearing, Amount: -int64(amount)},
+			{Account: model.AccountDepositHolds, Amount: int64(amount)},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var depositID int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO deposit_reservations (uuid, amount, hold_entry_id)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, uuid, amount, entryID).Scan(&depositID)
+	if err != nil {
+		return 0, err
+	}
+	return depositID, tx.Commit()
+}
+
+// CreditDeposit moves a charged deposit from deposit_holds into the wallet
+// and records the deposit
+func (r *PostgresBalanceRepository) CreditDeposit(ctx context.Context, depositID int64) error {
+	return r.resolveDeposit(ctx, depositID, "credited", func(uuid string) string {
+		return model.WalletAccount(cleanUUID(uuid))
+	})
+}
+
+// ReleaseDeposit returns a deposit the card was not charged for to payment
+// clearing
+func (r *PostgresBalanceRepository) ReleaseDeposit(ctx context.Context, depositID int64) error {
+	return r.resolveDeposit(ctx, depositID, "released", func(string) string {
+		return model.AccountPaymentClearing
+	})
+}
+
+func (r *PostgresBalanceRepository) ListPendingDeposits(ctx context.Context, createdBefore time.Time) ([]model.PendingDeposit, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, uuid, amount, created_at
+		FROM deposit_reservations
+		WHERE status = 'pending' AND created_at < $1
+		ORDER BY created_at
+	`, createdBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := []model.PendingDeposit{}
+	for rows.Next() {
+		var p model.PendingDeposit
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Amount, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+	return pending, rows.Err()
+}
+
+func (r *PostgresBalanceRepository) resolveDeposit(ctx context.Context, depositID int64, status string, target func(uuid string) string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		uuid   string
+		amount int64
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT uuid, amount
+		FROM deposit_reservations
+		WHERE id = $1 AND status = 'pending'
+		FOR UPDATE
+	`, depositID).Scan(&uuid, &amount)
+	if err == sql.ErrNoRows {
+		return ErrReservationNotPending
+	}
+	if err != nil {
+		return err
+	}
+
+	entryID, err := postEntry(ctx, tx, model.JournalEntry{
+		Kind:      "deposit_" + status,
+		Reference: uuid,
+		Postings: []model.Posting{
+			{Account: model.AccountDepositHolds, Amount: -amount},
+			{Account: target(uuid), Amount: amount},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE deposit_reservations
+		SET status = $2, resolve_entry_id = $3, resolved_at = now()
+		WHERE id = $1
+	`, depositID, status, entryID)
+	if err != nil {
+		return err
+	}
+
+	if status == "credited" {
+		// the credit is the entry that moved the wallet
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO transactions (uuid, amount, type, entry_id)
+			VALUES ($1, $2, 'deposit', $3)
+		`, uuid, amount, entryID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,98 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"transervice/internal/data/model"
+)
+
+type PostgresIdempotencyRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresIdempotencyRepository(db *sql.DB) IdempotencyRepository {
+	return &PostgresIdempotencyRepository{db: db}
+}
+
+func (r *PostgresIdempotencyRepository) ClaimIdempotencyKey(ctx context.Context, scope, key, requestHash string, lease, retention time.Duration) (*model.StoredResponse, error) {
+	// a stale claim or an expired response is replaced as if the key were new
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (scope, key, request_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, claimed_at = now(), created_at = now(),
+			status_code = NULL, response_body = NULL, completed_at = NULL
+		WHERE (idempotency_keys.completed_at IS NULL AND idempotency_keys.claimed_at < now() - make_interval(secs => $4))
+			OR idempotency_keys.completed_at < now() - make_interval(secs => $5)
+	`, scope, key, requestHash, lease.Seconds(), retention.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 1 {
+		return nil, nil
+	}
+
+	var (
+		storedHash string
+		statusCode sql.NullInt64
+		body       []byte
+	)
+	err = r.db.QueryRowContext(ctx, `
+		SELECT request_hash, status_code, response_body
+		FROM idempotency_keys
+		WHERE scope = $1 AND key = $2
+	`, scope, key).Scan(&storedHash, &statusCode, &body)
+	if err == sql.ErrNoRows {
+		// released between the insert and the select
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+	if storedHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !statusCode.Valid {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return &model.StoredResponse{StatusCode: int(statusCode.Int64), Body: body}, nil
+}
+
+func (r *PostgresIdempotencyRepository) SaveIdempotentResponse(ctx context.Context, scope, key string, response model.StoredResponse) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET status_code = $3, response_body = $4, completed_at = now()
+		WHERE scope = $1 AND key = $2 AND completed_at IS NULL
+	`, scope, key, response.StatusCode, response.Body)
+	return err
+}
+
+func (r *PostgresIdempotencyRepository) DeleteIdempotencyKeys(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE (scope, key) IN (
+			SELECT scope, key FROM idempotency_keys
+			WHERE COALESCE(completed_at, claimed_at) < $1
+			LIMIT $2
+		)
+	`, cutoff, limit)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	return int(rows), err
+}
+
+func (r *PostgresIdempotencyRepository) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE scope = $1 AND key = $2 AND completed_at IS NULL
+	`, scope, key)
+	return err
+}
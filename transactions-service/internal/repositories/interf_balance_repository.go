@@ -2,12 +2,34 @@ package repositories
 
 import (
 	"context"
+	"time"
+
+	"transervice/internal/data/model"
 )
 
 type BalanceRepository interface {
-	IsThereEnoughMoneyByUUID(ctx context.Context, uuid string, amount int) (bool, error)
 	UpdateBalanceByUUID(ctx context.Context, uuid string, amount int) error
 	UpdateBalanceByUUIDPAY(ctx context.Context, uuid string, amount int) error
-	UpdateBalanceByUUIDWithDrawal(ctx context.Context, uuid string, amount int) error
-	TransactionCreate(ctx context.Context, uuid string, amount int, transactionType string) error
+	// ReserveWithdrawal takes amount out of the wallet until the withdrawal
+	// is settled or released, or fails with ErrInsufficientFunds
+	ReserveWithdrawal(ctx context.Context, uuid string, amount int) (int64, error)
+	// SettleWithdrawal and ReleaseWithdrawal fail with
+	// ErrReservationNotPending for a reservation already resolved
+	SettleWithdrawal(ctx context.Context, reservationID int64) error
+	ReleaseWithdrawal(ctx context.Context, reservationID int64) error
+	// ListPendingWithdrawals returns the reservations created before
+	// createdBefore that are still pending, oldest first
+	ListPendingWithdrawals(ctx context.Context, createdBefore time.Time) ([]model.PendingWithdrawal, error)
+	// ReserveDeposit records a card deposit before the card is charged.
+	// CreditDeposit and ReleaseDeposit fail with ErrReservationNotPending
+	// for a deposit already resolved.
+	ReserveDeposit(ctx context.Context, uuid string, amount int) (int64, error)
+	CreditDeposit(ctx context.Context, depositID int64) error
+	ReleaseDeposit(ctx context.Context, depositID int64) error
+	// ListPendingDeposits returns the deposits created before createdBefore
+	// that are still pending, oldest first
+	ListPendingDeposits(ctx context.Context, createdBefore time.Time) ([]model.PendingDeposit, error)
+	ListTransactions(ctx context.Context, filter model.TransactionFilter) ([]model.Transaction, error)
+	// CheckLedger lists the wallets whose balances do not add up
+	CheckLedger(ctx context.Context) ([]model.LedgerMismatch, error)
 }
\ No newline at end of file
@@ -0,0 +1,29 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"transervice/internal/data/model"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was used for a different request")
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+type IdempotencyRepository interface {
+	// ClaimIdempotencyKey reserves key within scope for the request with
+	// requestHash. It returns nil when the caller should go on and process
+	// the request, or the stored response when it was processed before. A
+	// claim older than lease that never completed is taken over, and so is a
+	// key completed more than retention ago.
+	ClaimIdempotencyKey(ctx context.Context, scope, key, requestHash string, lease, retention time.Duration) (*model.StoredResponse, error)
+	SaveIdempotentResponse(ctx context.Context, scope, key string, response model.StoredResponse) error
+	// ReleaseIdempotencyKey forgets a claim that produced no response
+	ReleaseIdempotencyKey(ctx context.Context, scope, key string) error
+	// DeleteIdempotencyKeys deletes up to limit keys last used before cutoff
+	// and returns how many were deleted
+	DeleteIdempotencyKeys(ctx context.Context, cutoff time.Time, limit int) (int, error)
+}
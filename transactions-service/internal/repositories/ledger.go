@@ -0,0 +1,170 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+
+	"transervice/internal/data/model"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrInvalidAmount     = errors.New("amount must be positive")
+	ErrUnbalancedEntry   = errors.New("journal entry does not balance")
+)
+
+// postEntry books entry inside tx and returns its id. Wallet balances are
+// updated in the same statement order for every caller, sorted by account,
+// so concurrent entries cannot deadlock. A debit that would take a wallet
+// below zero gives ErrInsufficientFunds. users.balance is kept in step for readers that
+// have not moved to the ledger yet.
+func postEntry(ctx context.Context, tx *sql.Tx, entry model.JournalEntry) (int64, error) {
+	var sum int64
+	for _, posting := range entry.Postings {
+		if posting.Amount == 0 {
+			return 0, ErrUnbalancedEntry
+		}
+		sum += posting.Amount
+	}
+	if sum != 0 || len(entry.Postings) < 2 {
+		return 0, ErrUnbalancedEntry
+	}
+
+	var entryID int64
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO journal_entries (kind, reference)
+		VALUES ($1, $2)
+		RETURNING id
+	`, entry.Kind, entry.Reference).Scan(&entryID)
+	if err != nil {
+		return 0, err
+	}
+
+	postings := slices.Clone(entry.Postings)
+	slices.SortFunc(postings, func(a, b model.Posting) int {
+		return strings.Compare(a.Account, b.Account)
+	})
+	for _, posting := range postings {
+		accountID, err := applyPosting(ctx, tx, posting)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO journal_postings (entry_id, account_id, amount)
+			VALUES ($1, $2, $3)
+		`, entryID, accountID, posting.Amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return entryID, nil
+}
+
+// applyPosting creates the account if needed and, for wallets, moves its
+// cached balance. Only debits have to leave the wallet at zero or above:
+// wallets carried over with a negative balance still take credits.
+func applyPosting(ctx context.Context, tx *sql.Tx, posting model.Posting) (int64, error) {
+	owner, isWallet := strings.CutPrefix(posting.Account, "wallet:")
+	if !isWallet {
+		var accountID int64
+		err := tx.QueryRowContext(ctx, `SELECT id FROM ledger_accounts WHERE code = $1`, posting.Account).Scan(&accountID)
+		return accountID, err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO ledger_accounts (code, kind, owner_uuid)
+		VALUES ($1, 'wallet', $2)
+		ON CONFLICT (code) DO NOTHING
+	`, posting.Account, owner)
+	if err != nil {
+		return 0, err
+	}
+
+	// the condition is evaluated on the locked row, so the check and the
+	// debit are one step for concurrent postings
+	var accountID int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE ledger_accounts
+		SET balance = balance + $2
+		WHERE code = $1 AND ($2 > 0 OR balance + $2 >= 0)
+		RETURNING id
+	`, posting.Account, posting.Amount).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInsufficientFunds
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE users SET balance = balance + $1 WHERE uuid = $2`, posting.Amount, owner)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO users (uuid, balance) VALUES ($1, $2)`, owner, posting.Amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return accountID, nil
+}
+
+// postTransaction books entry and records it in transactions as one unit
+func (r *PostgresBalanceRepository) postTransaction(ctx context.Context, uuid string, amount int, transactionType string, entry model.JournalEntry) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	entryID, err := postEntry(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO transactions (uuid, amount, type, entry_id)
+		VALUES ($1, $2, $3, $4)
+	`, uuid, amount, transactionType, entryID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresBalanceRepository) CheckLedger(ctx context.Context) ([]model.LedgerMismatch, error) {
+	query := `
+		SELECT a.code, a.balance,
+		       COALESCE((SELECT SUM(p.amount) FROM journal_postings p WHERE p.account_id = a.id), 0),
+		       COALESCE((SELECT SUM(u.balance) FROM users u WHERE u.uuid = a.owner_uuid), 0)
+		FROM ledger_accounts a
+		WHERE a.kind = 'wallet'
+	`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mismatches := []model.LedgerMismatch{}
+	for rows.Next() {
+		var m model.LedgerMismatch
+		if err := rows.Scan(&m.Account, &m.Cached, &m.Posted, &m.UserBalance); err != nil {
+			return nil, err
+		}
+		if m.Cached != m.Posted || m.Cached != m.UserBalance {
+			mismatches = append(mismatches, m)
+		}
+	}
+	return mismatches, rows.Err()
+}
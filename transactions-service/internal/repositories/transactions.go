@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"transervice/internal/data/model"
+)
+
+// ListTransactions returns the transactions of filter.UserID, newest first.
+// Users are stored both as the profile uuid and in the cleaned form, so both
+// are matched.
+func (r *PostgresBalanceRepository) ListTransactions(ctx context.Context, filter model.TransactionFilter) ([]model.Transaction, error) {
+	forms := []interface{}{filter.UserID}
+	if !strings.HasPrefix(filter.UserID, "0x") {
+		forms = append(forms, cleanUUID(filter.UserID))
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	users := make([]string, len(forms))
+	for i, form := range forms {
+		users[i] = "t.uuid = " + arg(form)
+	}
+	conditions = append(conditions, "("+strings.Join(users, " OR ")+")")
+	if filter.Type != "" {
+		conditions = append(conditions, "t.type = "+arg(filter.Type))
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "t.time >= "+arg(filter.From.UTC()))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "t.time < "+arg(filter.To.UTC()))
+	}
+	if filter.Before > 0 {
+		conditions = append(conditions, "t.id < "+arg(filter.Before))
+	}
+
+	query := `
+		SELECT t.id, t.type, t.amount, t.time,
+		       (SELECT SUM(p.amount) FROM journal_postings p
+		        WHERE p.account_id = w.account_id AND p.entry_id <= t.entry_id)
+		FROM transactions t
+		LEFT JOIN LATERAL (
+			SELECT p.account_id
+			FROM journal_postings p
+			JOIN ledger_accounts a ON a.id = p.account_id
+			WHERE p.entry_id = t.entry_id AND a.kind = 'wallet'
+			LIMIT 1
+		) w ON true
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY t.id DESC
+		LIMIT ` + arg(filter.Limit)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []model.Transaction{}
+	for rows.Next() {
+		var (
+			t            model.Transaction
+			balanceAfter sql.NullInt64
+		)
+		if err := rows.Scan(&t.ID, &t.Type, &t.Amount, &t.Time, &balanceAfter); err != nil {
+			return nil, err
+		}
+		if balanceAfter.Valid {
+			t.BalanceAfter = &balanceAfter.Int64
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
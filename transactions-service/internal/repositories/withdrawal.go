@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"transervice/internal/data/model"
+)
+
+var ErrReservationNotPending = errors.New("reservation is not pending")
+
+// ReserveWithdrawal moves amount from the wallet into withdrawal_holds. The
+// balance check on the wallet row is part of the debit, so concurrent
+// withdrawals cannot both spend the same money.
+func (r *PostgresBalanceRepository) ReserveWithdrawal(ctx context.Context, uuid string, amount int) (int64, error) {
+	if amount <= 0 {
+		return 0, ErrInvalidAmount
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	entryID, err := postEntry(ctx, tx, model.JournalEntry{
+		Kind:      "withdrawal_hold",
+		Reference: uuid,
+		Postings: []model.Posting{
+			{Account: model.WalletAccount(cleanUUID(uuid)), Amount: -int64(amount)},
+			{Account: model.AccountWithdrawalHolds, Amount: int64(amount)},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var reservationID int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO withdrawal_reservations (uuid, amount, hold_entry_id)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, uuid, amount, entryID).Scan(&reservationID)
+	if err != nil {
+		return 0, err
+	}
+	return reservationID, tx.Commit()
+}
+
+// SettleWithdrawal passes the held money on to the payment provider and
+// records the withdrawal
+func (r *PostgresBalanceRepository) SettleWithdrawal(ctx context.Context, reservationID int64) error {
+	return r.resolveWithdrawal(ctx, reservationID, "settled", func(string) string {
+		return model.AccountPaymentClearing
+	})
+}
+
+// ReleaseWithdrawal gives the held money back to the wallet
+func (r *PostgresBalanceRepository) ReleaseWithdrawal(ctx context.Context, reservationID int64) error {
+	return r.resolveWithdrawal(ctx, reservationID, "released", func(uuid string) string {
+		return model.WalletAccount(cleanUUID(uuid))
+	})
+}
+
+func (r *PostgresBalanceRepository) ListPendingWithdrawals(ctx context.Context, createdBefore time.Time) ([]model.PendingWithdrawal, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, uuid, amount, created_at
+		FROM withdrawal_reservations
+		WHERE status = 'pending' AND created_at < $1
+		ORDER BY created_at
+	`, createdBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := []model.PendingWithdrawal{}
+	for rows.Next() {
+		var p model.PendingWithdrawal
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Amount, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+	return pending, rows.Err()
+}
+
+func (r *PostgresBalanceRepository) resolveWithdrawal(ctx context.Context, reservationID int64, status string, target func(uuid string) string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		uuid        string
+		amount      int64
+		holdEntryID int64
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT uuid, amount, hold_entry_id
+		FROM withdrawal_reservations
+		WHERE id = $1 AND status = 'pending'
+		FOR UPDATE
+	`, reservationID).Scan(&uuid, &amount, &holdEntryID)
+	if err == sql.ErrNoRows {
+		return ErrReservationNotPending
+	}
+	if err != nil {
+		return err
+	}
+
+	entryID, err := postEntry(ctx, tx, model.JournalEntry{
+		Kind:      "withdrawal_" + status,
+		Reference: uuid,
+		Postings: []model.Posting{
+			{Account: model.AccountWithdrawalHolds, Amount: -amount},
+			{Account: target(uuid), Amount: amount},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE withdrawal_reservations
+		SET status = $2, resolve_entry_id = $3, resolved_at = now()
+		WHERE id = $1
+	`, reservationID, status, entryID)
+	if err != nil {
+		return err
+	}
+
+	if status == "settled" {
+		// the hold is the entry that moved the wallet
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO transactions (uuid, amount, type, entry_id)
+			VALUES ($1, $2, 'withdrawal', $3)
+		`, uuid, amount, holdEntryID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
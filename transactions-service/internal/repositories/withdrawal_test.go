@@ -0,0 +1,105 @@
+package repositories
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"transervice/internal/data/model"
+)
+
+// TestReserveWithdrawalConcurrent runs against the database in DATABASE_URL,
+// which must have the migrations applied
+func TestReserveWithdrawalConcurrent(t *testing.T) {
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	db, err := NewDatabase(dbURL)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer db.Close()
+
+	const (
+		deposit  = 1000
+		amount   = 70
+		attempts = 40
+	)
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	uuid := hex.EncodeToString(raw)
+	wallet := model.WalletAccount(cleanUUID(uuid))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	repo := NewPostgresBalanceRepository(db)
+	if err := repo.UpdateBalanceByUUID(ctx, uuid, deposit); err != nil {
+		t.Fatalf("deposit: %v", err)
+	}
+
+	balance := func() int64 {
+		var b int64
+		if err := db.QueryRowContext(ctx, `SELECT balance FROM ledger_accounts WHERE code = $1`, wallet).Scan(&b); err != nil {
+			t.Errorf("read balance: %v", err)
+		}
+		return b
+	}
+
+	// watch the balance while the reservations race
+	done := make(chan struct{})
+	lowest := make(chan int64)
+	go func() {
+		low := int64(deposit)
+		for {
+			select {
+			case <-done:
+				lowest <- low
+				return
+			default:
+				low = min(low, balance())
+			}
+		}
+	}()
+
+	var (
+		wg        sync.WaitGroup
+		succeeded atomic.Int64
+		start     = make(chan struct{})
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := repo.ReserveWithdrawal(ctx, uuid, amount)
+			switch {
+			case err == nil:
+				succeeded.Add(1)
+			case !errors.Is(err, ErrInsufficientFunds):
+				t.Errorf("reserve: %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(done)
+
+	if low := <-lowest; low < 0 {
+		t.Errorf("balance went down to %d", low)
+	}
+	if got, want := succeeded.Load(), int64(deposit/amount); got != want {
+		t.Errorf("%d reservations succeeded, want %d", got, want)
+	}
+	if got, want := balance(), int64(deposit-deposit/amount*amount); got != want {
+		t.Errorf("balance is %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+// Package fakegateway is an in-process stand-in for the payment provider,
+// for local runs and tests
+package fakegateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Outcome is how the fake gateway answers a request
+type Outcome string
+
+const (
+	Success        Outcome = "success"
+	InvalidCard    Outcome = "invalid_card"
+	NotEnoughMoney Outcome = "not_enough_money"
+	ServerError    Outcome = "error"
+	// Unreachable drops the connection without an answer
+	Unreachable Outcome = "unreachable"
+)
+
+// Request is a request the fake gateway received
+type Request struct {
+	Operation  string // "charge" or "payout"
+	CardNumber string
+	Amount     int
+	Outcome    Outcome
+}
+
+// Server speaks the provider API on a loopback port, so HTTPPaymentGateway
+// can be pointed at URL. Every card succeeds unless outcomes were scripted
+// for it.
+type Server struct {
+	URL string
+
+	server   *http.Server
+	mu       sync.Mutex
+	scripts  map[string][]Outcome
+	requests []Request
+}
+
+// NewServer starts a fake gateway on a free loopback port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("fake gateway: %w", err)
+	}
+	f := &Server{scripts: make(map[string][]Outcome)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/payment/pay", f.handle("charge"))
+	mux.HandleFunc("/api/payment/addMoney", f.handle("payout"))
+	f.server = &http.Server{Handler: mux}
+	f.URL = "http://" + listener.Addr().String()
+	go f.server.Serve(listener)
+	return f, nil
+}
+
+// Script makes the next requests for cardNumber answer with outcomes, in
+// order. The last outcome keeps being used once the others are consumed.
+func (f *Server) Script(cardNumber string, outcomes ...Outcome) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripts[cardNumber] = outcomes
+}
+
+// ScriptFromString reads scripts written as
+// "card:outcome,outcome;card:outcome", as used by FAKE_GATEWAY_SCRIPT
+func (f *Server) ScriptFromString(script string) error {
+	for _, entry := range strings.Split(script, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		cardNumber, list, ok := strings.Cut(entry, ":")
+		if !ok {
+			return fmt.Errorf("fake gateway script %q: expected card:outcomes", entry)
+		}
+		var outcomes []Outcome
+		for _, name := range strings.Split(list, ",") {
+			outcome := Outcome(strings.TrimSpace(name))
+			switch outcome {
+			case Success, InvalidCard, NotEnoughMoney, ServerError, Unreachable:
+			default:
+				return fmt.Errorf("fake gateway script %q: unknown outcome %q", entry, outcome)
+			}
+			outcomes = append(outcomes, outcome)
+		}
+		f.Script(strings.TrimSpace(cardNumber), outcomes...)
+	}
+	return nil
+}
+
+// Requests returns the requests received so far
+func (f *Server) Requests() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request(nil), f.requests...)
+}
+
+func (f *Server) Close() {
+	f.server.Close()
+}
+
+func (f *Server) next(cardNumber string) Outcome {
+	outcomes := f.scripts[cardNumber]
+	if len(outcomes) == 0 {
+		return Success
+	}
+	if len(outcomes) > 1 {
+		f.scripts[cardNumber] = outcomes[1:]
+	}
+	return outcomes[0]
+}
+
+func (f *Server) handle(operation string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var payload struct {
+			CardNumber    string `json:"cardNumber"`
+			PaymentAmount int    `json:"paymentAmount"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		outcome := f.next(payload.CardNumber)
+		f.requests = append(f.requests, Request{
+			Operation:  operation,
+			CardNumber: payload.CardNumber,
+			Amount:     payload.PaymentAmount,
+			Outcome:    outcome,
+		})
+		f.mu.Unlock()
+
+		switch outcome {
+		case InvalidCard:
+			http.Error(w, "Invalid Credentials", http.StatusBadRequest)
+		case NotEnoughMoney:
+			http.Error(w, "Not enough money", http.StatusBadRequest)
+		case ServerError:
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		case Unreachable:
+			if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+				conn.Close()
+			}
+		default:
+			w.Write([]byte("OK"))
+		}
+	}
+}
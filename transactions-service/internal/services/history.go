@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+
+	"transervice/internal/data/model"
+)
+
+const (
+	DefaultTransactionPage = 50
+	MaxTransactionPage     = 200
+)
+
+// historyViewerRoles may read the transaction history of other users
+var historyViewerRoles = []string{"support", "admin"}
+
+var (
+	ErrForbidden              = errors.New("forbidden")
+	ErrInvalidTransactionType = errors.New("invalid transaction type")
+)
+
+// TransactionHistory returns a page of transactions of the user the token
+// belongs to, or of filter.UserID when the token belongs to support staff
+func (s *BalanceService) TransactionHistory(ctx context.Context, accessToken string, filter model.TransactionFilter) ([]model.Transaction, error) {
+	switch filter.Type {
+	case "", "deposit", "withdrawal", "payout":
+	default:
+		return nil, ErrInvalidTransactionType
+	}
+
+	uuid, err := GetUserUUID(accessToken)
+	if err != nil {
+		log.Printf("ERROR: Failed to get user UUID, invalid token: %v", err)
+		return nil, ErrInvalidCredentials
+	}
+	if filter.UserID != "" && filter.UserID != uuid {
+		role, err := GetUserRole(ctx, accessToken)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(historyViewerRoles, role) {
+			return nil, ErrForbidden
+		}
+	} else {
+		filter.UserID = uuid
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultTransactionPage
+	}
+	filter.Limit = min(filter.Limit, MaxTransactionPage)
+	return s.balanceRepo.ListTransactions(ctx, filter)
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"transervice/internal/data/model"
+	repository "transervice/internal/repositories"
+)
+
+// MaxIdempotencyKeyLength bounds the Idempotency-Key header and payoutId
+const MaxIdempotencyKeyLength = 255
+
+const (
+	// IdempotencyKeyLease is how long a claim blocks retries. Requests give
+	// up long before it runs out, so a claim this old belongs to a request
+	// that died without answering.
+	IdempotencyKeyLease = 5 * time.Minute
+	// IdempotencyKeyRetention is how long responses are replayed
+	IdempotencyKeyRetention = 24 * time.Hour
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key reused with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+// ClaimIdempotencyKey starts processing the request identified by key within
+// scope. requestHash fingerprints the payload so that a key cannot be reused
+// for another request. A non-nil response means the request was processed
+// before and its response should be replayed.
+func (s *BalanceService) ClaimIdempotencyKey(ctx context.Context, scope, key, requestHash string) (*model.StoredResponse, error) {
+	stored, err := s.idempotencyRepo.ClaimIdempotencyKey(ctx, scope, key, requestHash, IdempotencyKeyLease, IdempotencyKeyRetention)
+	switch {
+	case errors.Is(err, repository.ErrIdempotencyKeyReused):
+		return nil, ErrIdempotencyKeyReused
+	case errors.Is(err, repository.ErrIdempotencyKeyInProgress):
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return stored, err
+}
+
+// CompleteIdempotencyKey stores the response for a claimed key. Without a
+// response the claim is dropped so the client can retry with the same key.
+// It runs detached from ctx: the request context may already be done, and
+// losing the response would let a retry move the money again.
+func (s *BalanceService) CompleteIdempotencyKey(ctx context.Context, scope, key string, response *model.StoredResponse) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 3*time.Second)
+	defer cancel()
+
+	if response == nil {
+		return s.idempotencyRepo.ReleaseIdempotencyKey(ctx, scope, key)
+	}
+	return s.idempotencyRepo.SaveIdempotentResponse(ctx, scope, key, *response)
+}
+
+// PurgeIdempotencyKeys deletes keys past their retention every interval
+// until ctx is done
+func (s *BalanceService) PurgeIdempotencyKeys(ctx context.Context, interval time.Duration) {
+	const batch = 1000
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			deleted, err := s.idempotencyRepo.DeleteIdempotencyKeys(ctx, time.Now().Add(-IdempotencyKeyRetention), batch)
+			if err != nil {
+				log.Printf("Idempotency key cleanup failed: %v", err)
+				break
+			}
+			if deleted > 0 {
+				log.Printf("Deleted %d expired idempotency keys", deleted)
+			}
+			if deleted < batch {
+				break
+			}
+		}
+	}
+}
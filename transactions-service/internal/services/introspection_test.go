@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// unsignedToken builds a JWT with payload as its claims; introspection does
+// not check signatures itself
+func unsignedToken(payload string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"HS256"}`)) + "." + encode([]byte(payload)) + ".c2ln"
+}
+
+func TestIntrospectOnlyAsksIssuer(t *testing.T) {
+	var asked int
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked++
+		w.Write([]byte(`{"active":true,"sub":"42","act":{"sub":"7"}}`))
+	}))
+	defer auth.Close()
+	introspector := NewTokenIntrospector(auth.URL, "http://auth.test", "transactions-service", "secret", "")
+
+	tests := []struct {
+		name      string
+		token     string
+		wantAsked bool
+	}{
+		{"auth-service token", unsignedToken(`{"iss":"http://auth.test","sub":"42"}`), true},
+		{"player token", unsignedToken(`{"user_uuid":"42"}`), false},
+		{"other issuer", unsignedToken(`{"iss":"http://elsewhere.test"}`), false},
+		{"not a JWT", "opaque", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asked = 0
+			info, err := introspector.Introspect(context.Background(), tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := asked == 1; got != tt.wantAsked {
+				t.Errorf("asked auth-service: %t, want %t", got, tt.wantAsked)
+			}
+			if info.Active != tt.wantAsked {
+				t.Errorf("active = %t, want %t", info.Active, tt.wantAsked)
+			}
+		})
+	}
+}
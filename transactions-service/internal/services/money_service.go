@@ -1,23 +1,22 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"strings"
-	"transervice/model"
-	"transervice/repository"
+	"time"
+	"transervice/internal/data/model"
+	repository "transervice/internal/repositories"
 	"io"
 )
 
-const paymentURL = "https://arlan-api.azurewebsites.net/api/payment/pay"
-const paymentURL2 = "https://arlan-api.azurewebsites.net/api/payment/addMoney"
-const profileURL = "http://golang.medhelper.xyz/profile"
+// profileURL is the profile endpoint of the auth service that tokens are
+// resolved to users with
+var profileURL = "http://golang.medhelper.xyz/profile"
 
 var (
 	ErrNotEnoughMoney        = errors.New("Not enough money")
@@ -26,8 +25,21 @@ var (
 	ErrPaymentFailed         = errors.New("Error")
 	ErrUserNotFound      = errors.New("user not found")
     ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrInvalidAmount     = errors.New("amount must be positive")
+	// ErrNoMoneyMoved wraps failures that happened before any money moved,
+	// so the request may be retried as if it was never made
+	ErrNoMoneyMoved = errors.New("no money moved")
+
+	ErrInvalidWithdrawalAction = errors.New("action must be settle or release")
+	ErrWithdrawalNotPending    = errors.New("withdrawal is not pending")
+	ErrInvalidDepositAction    = errors.New("action must be credit or release")
+	ErrDepositNotPending       = errors.New("deposit is not pending")
 )
 
+// DefaultPendingWithdrawalAge is how long a withdrawal or deposit may wait
+// for the provider's outcome before the ledger check reports it
+const DefaultPendingWithdrawalAge = 15 * time.Minute
+
 // var secretKey []byte
 
 // func InitSecret(secret string) {
@@ -36,13 +48,17 @@ var (
 // }
 
 type BalanceService struct {
-	balanceRepo repository.BalanceRepository
+	balanceRepo     repository.BalanceRepository
+	idempotencyRepo repository.IdempotencyRepository
+	gateway         PaymentGateway
 }
 
-func NewBalanceService(balanceRepo repository.BalanceRepository) *BalanceService {
+func NewBalanceService(balanceRepo repository.BalanceRepository, idempotencyRepo repository.IdempotencyRepository, gateway PaymentGateway) *BalanceService {
 	log.Println("Creating new BalanceService")
 	return &BalanceService{
-		balanceRepo: balanceRepo,
+		balanceRepo:     balanceRepo,
+		idempotencyRepo: idempotencyRepo,
+		gateway:         gateway,
 	}
 }
 
@@ -78,69 +94,102 @@ func GetUserUUID(token string) (string, error) {
 }
 
 
-func (s *BalanceService) Replenishment(ctx context.Context, accessToken, cardNumber, cardOwner, cvv string, amount int) (*model.Response, error) {
-	uuid, err := GetUserUUID(accessToken)
+// GetUserRole returns the role of the user the token belongs to, as reported
+// by the profile endpoint of the auth service. A rejected token gives
+// ErrInvalidCredentials; an auth service that cannot answer gives
+// ErrAuthUnavailable.
+func GetUserRole(ctx context.Context, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", profileURL, nil)
 	if err != nil {
-		log.Printf("ERROR: Failed to get user UUID, invalid token: %v", err)
-		return nil, ErrInvalidCredentials
+		return "", fmt.Errorf("failed to create request: %w", err)
 	}
-	
-	reqBody, err := json.Marshal(map[string]interface{}{
-		"cardNumber": cardNumber,
-		"cardOwnerName": cardOwner,
-		"cvv": cvv,
-		"paymentAmount": amount,
-	})
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Printf("ERROR: Failed to marshal payment request: %v", err)
-		return nil, err
+		return "", fmt.Errorf("%w: %v", ErrAuthUnavailable, err)
 	}
+	defer resp.Body.Close()
 
-	resp, err := http.Post(paymentURL, "application/json", bytes.NewReader(reqBody))
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", ErrInvalidCredentials
+	default:
+		return "", fmt.Errorf("%w: profile answered %d", ErrAuthUnavailable, resp.StatusCode)
+	}
+
+	var result struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("%w: failed to decode response: %v", ErrAuthUnavailable, err)
+	}
+	return result.Role, nil
+}
+
+func (s *BalanceService) Replenishment(ctx context.Context, accessToken, cardNumber, cardOwner, cvv string, amount int) (*model.Response, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	uuid, err := GetUserUUID(accessToken)
 	if err != nil {
-		log.Printf("ERROR: Failed to send payment request: %v", err)
-		return nil, err
+		log.Printf("ERROR: Failed to get user UUID, invalid token: %v", err)
+		return nil, ErrInvalidCredentials
 	}
-	defer resp.Body.Close()
 	
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+
+	// the charge is on record before the provider is asked to make it, so a
+	// card charged for a deposit that never reached the wallet can be found
+	depositID, err := s.balanceRepo.ReserveDeposit(ctx, uuid, amount)
 	if err != nil {
-		log.Printf("ERROR: Failed to read response body: %v", err)
-		return nil, err
+		log.Printf("ERROR: Failed to record deposit: %v", err)
+		return nil, fmt.Errorf("%w: %w", ErrNoMoneyMoved, err)
 	}
-	
-	bodyStr := strings.TrimSpace(string(bodyBytes))
-	log.Printf("Payment API response: Status: %d, Body: %s", resp.StatusCode, bodyStr)
-	
+	log.Printf("Deposit %d recorded", depositID)
+
+	err = s.gateway.Charge(ctx, Card{Number: cardNumber, Owner: cardOwner, CVV: cvv}, amount)
 	switch {
-	case resp.StatusCode == http.StatusOK:
-		log.Println("Payment successful, updating balance in database")
-		err := s.balanceRepo.UpdateBalanceByUUID(ctx, uuid, amount)
-		if err != nil {
-			log.Printf("ERROR: Failed to update balance in database: %v", err)
+	case err == nil:
+		log.Printf("Payment successful, crediting deposit %d", depositID)
+		if err := s.resolveDeposit(ctx, depositID, true); err != nil {
 			return nil, err
 		}
 		log.Println("Balance successfully updated")
-
-		err = s.balanceRepo.TransactionCreate(ctx, uuid, amount, "deposit")
-        if err != nil {
-            log.Printf("ERROR: Failed to create transaction record: %v", err)
-            return nil, err
-        }
 		return &model.Response{Message: "Balance successfully replenished"}, nil
-		
-	case resp.StatusCode == http.StatusBadRequest && strings.Contains(bodyStr, "Invalid Credentials"):
-		log.Println("ERROR: Invalid card credentials")
-		return nil, ErrInvalidCredentialsCard
-		
-	case resp.StatusCode == http.StatusBadRequest && strings.Contains(bodyStr, "Not enough money"):
-		log.Println("ERROR: Not enough money on card")
-		return nil, ErrNotEnoughMoney
-		
+
+	case errors.Is(err, ErrInvalidCredentialsCard), errors.Is(err, ErrNotEnoughMoney):
+		log.Printf("ERROR: Charge refused, releasing deposit %d: %v", depositID, err)
+		if err := s.resolveDeposit(ctx, depositID, false); err != nil {
+			return nil, err
+		}
+		return nil, err
+
 	default:
-		log.Printf("ERROR: Unexpected response - Status: %d, Body: %s", resp.StatusCode, bodyStr)
-		return nil, fmt.Errorf("%w: %s", ErrPaymentFailed, bodyStr)
+		// the card may or may not have been charged: the deposit stays
+		// pending until someone checks with the provider
+		log.Printf("ERROR: Charge failed, deposit %d left pending: %v", depositID, err)
+		return nil, err
+	}
+}
+
+// resolveDeposit credits or releases a deposit once the provider has
+// answered. Like resolveWithdrawal it runs detached from ctx; when it fails
+// the deposit stays pending for finance to resolve.
+func (s *BalanceService) resolveDeposit(ctx context.Context, depositID int64, credit bool) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 3*time.Second)
+	defer cancel()
+
+	var err error
+	if credit {
+		err = s.balanceRepo.CreditDeposit(ctx, depositID)
+	} else {
+		err = s.balanceRepo.ReleaseDeposit(ctx, depositID)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to resolve deposit %d (credit=%t), left pending: %v", depositID, credit, err)
 	}
+	return err
 }
 
 // Helper functions for logging
@@ -166,6 +215,9 @@ func maskJSON(jsonStr string) string {
 }
 
 func (s *BalanceService) Withdrawal(ctx context.Context, accessToken, cardNumber string, amount int) (*model.Response, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
 	uuid, err := GetUserUUID(accessToken)
 	if err != nil {
 		log.Printf("ERROR: Failed to get user UUID, invalid token: %v", err)
@@ -173,77 +225,134 @@ func (s *BalanceService) Withdrawal(ctx context.Context, accessToken, cardNumber
 	}
 	
 
-	ok, err := s.balanceRepo.IsThereEnoughMoneyByUUID(ctx, uuid, amount)
-	if err != nil {
-		log.Printf("Error checking balance: %v", err)
-		return nil, err
-	}
-	if !ok {
+	// the money leaves the wallet before the provider is asked to send it, so
+	// concurrent withdrawals cannot both spend it
+	reservationID, err := s.balanceRepo.ReserveWithdrawal(ctx, uuid, amount)
+	if errors.Is(err, repository.ErrInsufficientFunds) {
 		log.Printf("Error: Not enough money in balance for withdrawal")
-		return nil, ErrNotEnoughMoney
-	}
-	log.Printf("Balance check passed: sufficient funds available")
-
-	reqBody, _ := json.Marshal(map[string]interface{}{
-		"cardNumber":    cardNumber,
-		"paymentAmount": amount,
-	})
-	log.Printf("Payment request prepared: %s", string(reqBody))
-	
-	log.Printf("Sending payment request to %s", paymentURL2)
-	resp, err := http.Post(paymentURL2, "application/json", bytes.NewReader(reqBody))
-	if err != nil {
-		log.Printf("Error sending payment request: %v", err)
-		return nil, err
+		return nil, ErrInsufficientFunds
 	}
-	defer resp.Body.Close()
-	log.Printf("Payment request sent. Status code: %d", resp.StatusCode)
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return nil, err
+		log.Printf("Error reserving withdrawal: %v", err)
+		return nil, fmt.Errorf("%w: %w", ErrNoMoneyMoved, err)
 	}
-	bodyStr := strings.TrimSpace(string(bodyBytes))
-	log.Printf("Payment response received: [%d] %s", resp.StatusCode, bodyStr)
+	log.Printf("Withdrawal %d reserved", reservationID)
 
+	err = s.gateway.Payout(ctx, cardNumber, amount)
 	switch {
-	case resp.StatusCode == http.StatusOK:
-		log.Printf("Payment successful, updating balance")
-		err := s.balanceRepo.UpdateBalanceByUUIDWithDrawal(ctx, uuid, amount)
-		if err != nil {
-			log.Printf("Error updating balance: %v", err)
+	case err == nil:
+		log.Printf("Payment successful, settling withdrawal %d", reservationID)
+		if err := s.resolveWithdrawal(ctx, reservationID, true); err != nil {
 			return nil, err
 		}
 		log.Printf("Balance successfully updated")
-		err = s.balanceRepo.TransactionCreate(ctx, uuid, amount, "withdrawal")
-        if err != nil {
-            log.Printf("ERROR: Failed to create transaction record: %v", err)
-            return nil, err
-        }
 		return &model.Response{Message: "Balance successfully replenished to card back"}, nil
+	case errors.Is(err, ErrInvalidCredentialsCard), errors.Is(err, ErrNotEnoughMoney):
+		log.Printf("Error: Payout refused, releasing withdrawal %d: %v", reservationID, err)
+		if err := s.resolveWithdrawal(ctx, reservationID, false); err != nil {
+			return nil, err
+		}
+		return nil, err
+	default:
+		// no answer or one we do not understand: the provider may or may not
+		// have sent the money, the reservation stays pending until someone
+		// checks with the provider
+		log.Printf("Error sending payment request, withdrawal %d left reserved: %v", reservationID, err)
+		return nil, err
+	}
+}
 
-	case resp.StatusCode == http.StatusBadRequest && strings.Contains(bodyStr, "Invalid Credentials"):
-		log.Printf("Error: Invalid card credentials")
-		return nil, ErrInvalidCredentialsCard
-
-	case resp.StatusCode == http.StatusBadRequest && strings.Contains(bodyStr, "Not enough money"):
-		log.Printf("Error: Not enough money on card")
-		return nil, ErrNotEnoughMoney
+// resolveWithdrawal settles or releases a reservation once the provider has
+// answered. It runs detached from ctx, which the provider call may have used
+// up: the outcome is known now and must not be lost.
+func (s *BalanceService) resolveWithdrawal(ctx context.Context, reservationID int64, settle bool) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 3*time.Second)
+	defer cancel()
 
-	default:
-		log.Printf("Error: Payment failed with unexpected response: [%d] %s", resp.StatusCode, bodyStr)
-		return nil, ErrPaymentFailed
+	var err error
+	if settle {
+		err = s.balanceRepo.SettleWithdrawal(ctx, reservationID)
+	} else {
+		err = s.balanceRepo.ReleaseWithdrawal(ctx, reservationID)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to resolve withdrawal %d (settle=%t): %v", reservationID, settle, err)
 	}
+	return err
 }
 
 func (s *BalanceService) ProcessUserPayout(ctx context.Context, userID string, amount int) error {
-	
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	// the payout is booked in one transaction, a failure leaves nothing behind
 	err := s.balanceRepo.UpdateBalanceByUUIDPAY(ctx, userID, amount)
 	if err != nil {
 		log.Printf("ERROR: Failed to update balance in database: %v", err)
-		return err
+		return fmt.Errorf("%w: %w", ErrNoMoneyMoved, err)
 	}
 	log.Println("Balance successfully updated")
 	return nil
+}
+
+// CheckLedger reports the wallets whose cached balance, postings and
+// users.balance disagree. An empty result means the books are consistent.
+func (s *BalanceService) CheckLedger(ctx context.Context) ([]model.LedgerMismatch, error) {
+	return s.balanceRepo.CheckLedger(ctx)
+}
+
+// PendingWithdrawals lists the withdrawals that have waited longer than age
+// for the provider's outcome and need to be checked with the provider
+func (s *BalanceService) PendingWithdrawals(ctx context.Context, age time.Duration) ([]model.PendingWithdrawal, error) {
+	return s.balanceRepo.ListPendingWithdrawals(ctx, time.Now().Add(-age))
+}
+
+// ResolvePendingWithdrawal settles or releases a pending withdrawal by hand
+// once finance staff know from the provider whether the money was sent
+func (s *BalanceService) ResolvePendingWithdrawal(ctx context.Context, reservationID int64, action string) error {
+	var err error
+	switch action {
+	case "settle":
+		err = s.balanceRepo.SettleWithdrawal(ctx, reservationID)
+	case "release":
+		err = s.balanceRepo.ReleaseWithdrawal(ctx, reservationID)
+	default:
+		return ErrInvalidWithdrawalAction
+	}
+	if errors.Is(err, repository.ErrReservationNotPending) {
+		return ErrWithdrawalNotPending
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Withdrawal %d resolved by hand: %s", reservationID, action)
+	return nil
+}
+
+// PendingDeposits lists the deposits that have waited longer than age and
+// need to be checked with the provider
+func (s *BalanceService) PendingDeposits(ctx context.Context, age time.Duration) ([]model.PendingDeposit, error) {
+	return s.balanceRepo.ListPendingDeposits(ctx, time.Now().Add(-age))
+}
+
+// ResolvePendingDeposit credits or releases a pending deposit by hand once
+// finance staff know from the provider whether the card was charged
+func (s *BalanceService) ResolvePendingDeposit(ctx context.Context, depositID int64, action string) error {
+	var err error
+	switch action {
+	case "credit":
+		err = s.balanceRepo.CreditDeposit(ctx, depositID)
+	case "release":
+		err = s.balanceRepo.ReleaseDeposit(ctx, depositID)
+	default:
+		return ErrInvalidDepositAction
+	}
+	if errors.Is(err, repository.ErrReservationNotPending) {
+		return ErrDepositNotPending
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Deposit %d resolved by hand: %s", depositID, action)
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"transervice/internal/data/model"
+	repository "transervice/internal/repositories"
+	"transervice/internal/services/fakegateway"
+)
+
+// memoryBalanceRepository keeps one balance per user and the state of each
+// withdrawal and deposit reservation
+type memoryBalanceRepository struct {
+	mu          sync.Mutex
+	balances    map[string]int
+	withdrawals map[int64]*memoryReservation
+	deposits    map[int64]*memoryReservation
+	// failCredit makes crediting deposits fail as a lost database would
+	failCredit bool
+}
+
+type memoryReservation struct {
+	uuid   string
+	amount int
+	status string
+}
+
+func newMemoryBalanceRepository() *memoryBalanceRepository {
+	return &memoryBalanceRepository{
+		balances:    make(map[string]int),
+		withdrawals: make(map[int64]*memoryReservation),
+		deposits:    make(map[int64]*memoryReservation),
+	}
+}
+
+func (r *memoryBalanceRepository) UpdateBalanceByUUID(ctx context.Context, uuid string, amount int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.balances[uuid] += amount
+	return nil
+}
+
+func (r *memoryBalanceRepository) UpdateBalanceByUUIDPAY(ctx context.Context, uuid string, amount int) error {
+	return r.UpdateBalanceByUUID(ctx, uuid, amount)
+}
+
+func (r *memoryBalanceRepository) ReserveWithdrawal(ctx context.Context, uuid string, amount int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.balances[uuid] < amount {
+		return 0, repository.ErrInsufficientFunds
+	}
+	r.balances[uuid] -= amount
+	id := int64(len(r.withdrawals) + 1)
+	r.withdrawals[id] = &memoryReservation{uuid: uuid, amount: amount, status: "pending"}
+	return id, nil
+}
+
+// resolve moves a pending reservation to status, giving its amount to the
+// wallet when toWallet is set
+func (r *memoryBalanceRepository) resolve(reservation *memoryReservation, status string, toWallet bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if reservation == nil || reservation.status != "pending" {
+		return repository.ErrReservationNotPending
+	}
+	reservation.status = status
+	if toWallet {
+		r.balances[reservation.uuid] += reservation.amount
+	}
+	return nil
+}
+
+func (r *memoryBalanceRepository) withdrawal(id int64) *memoryReservation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.withdrawals[id]
+}
+
+func (r *memoryBalanceRepository) deposit(id int64) *memoryReservation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.deposits[id]
+}
+
+func (r *memoryBalanceRepository) SettleWithdrawal(ctx context.Context, id int64) error {
+	return r.resolve(r.withdrawal(id), "settled", false)
+}
+
+func (r *memoryBalanceRepository) ReleaseWithdrawal(ctx context.Context, id int64) error {
+	return r.resolve(r.withdrawal(id), "released", true)
+}
+
+func (r *memoryBalanceRepository) ListPendingWithdrawals(ctx context.Context, createdBefore time.Time) ([]model.PendingWithdrawal, error) {
+	return nil, nil
+}
+
+func (r *memoryBalanceRepository) ReserveDeposit(ctx context.Context, uuid string, amount int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := int64(len(r.deposits) + 1)
+	r.deposits[id] = &memoryReservation{uuid: uuid, amount: amount, status: "pending"}
+	return id, nil
+}
+
+func (r *memoryBalanceRepository) CreditDeposit(ctx context.Context, id int64) error {
+	if r.failCredit {
+		return errors.New("connection lost")
+	}
+	return r.resolve(r.deposit(id), "credited", true)
+}
+
+func (r *memoryBalanceRepository) ReleaseDeposit(ctx context.Context, id int64) error {
+	return r.resolve(r.deposit(id), "released", false)
+}
+
+func (r *memoryBalanceRepository) ListPendingDeposits(ctx context.Context, createdBefore time.Time) ([]model.PendingDeposit, error) {
+	return nil, nil
+}
+
+func (r *memoryBalanceRepository) ListTransactions(ctx context.Context, filter model.TransactionFilter) ([]model.Transaction, error) {
+	return nil, nil
+}
+
+func (r *memoryBalanceRepository) CheckLedger(ctx context.Context) ([]model.LedgerMismatch, error) {
+	return nil, nil
+}
+
+// state returns the balance of uuid and the status of a reservation
+func (r *memoryBalanceRepository) state(uuid string, reservation *memoryReservation) (int, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if reservation == nil {
+		return r.balances[uuid], ""
+	}
+	return r.balances[uuid], reservation.status
+}
+
+const testUserUUID = "6f1c2c0e-0000-4000-8000-000000000001"
+
+// newTestService wires a BalanceService to the fake gateway and to a profile
+// endpoint that knows every token as testUserUUID
+func newTestService(t *testing.T) (*BalanceService, *memoryBalanceRepository, *fakegateway.Server) {
+	t.Helper()
+	profile := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"uuid": testUserUUID})
+	}))
+	t.Cleanup(profile.Close)
+	previous := profileURL
+	profileURL = profile.URL
+	t.Cleanup(func() { profileURL = previous })
+
+	fake, err := fakegateway.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fake.Close() })
+
+	repo := newMemoryBalanceRepository()
+	return NewBalanceService(repo, nil, NewHTTPPaymentGateway(fake.URL)), repo, fake
+}
+
+func TestWithdrawalOutcomes(t *testing.T) {
+	tests := []struct {
+		outcome     fakegateway.Outcome
+		wantErr     error
+		wantBalance int
+		wantStatus  string
+	}{
+		{fakegateway.Success, nil, 60, "settled"},
+		{fakegateway.NotEnoughMoney, ErrNotEnoughMoney, 100, "released"},
+		{fakegateway.InvalidCard, ErrInvalidCredentialsCard, 100, "released"},
+		// the provider may have sent the money: keep it held
+		{fakegateway.ServerError, ErrPaymentFailed, 60, "pending"},
+		{fakegateway.Unreachable, ErrGatewayUnreachable, 60, "pending"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.outcome), func(t *testing.T) {
+			s, repo, fake := newTestService(t)
+			repo.balances[testUserUUID] = 100
+			fake.Script("4000000000000002", tt.outcome)
+
+			_, err := s.Withdrawal(context.Background(), "token", "4000000000000002", 40)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Withdrawal error = %v, want %v", err, tt.wantErr)
+			}
+			balance, status := repo.state(testUserUUID, repo.withdrawal(1))
+			if balance != tt.wantBalance || status != tt.wantStatus {
+				t.Errorf("balance %d, reservation %q; want %d, %q", balance, status, tt.wantBalance, tt.wantStatus)
+			}
+			if requests := fake.Requests(); len(requests) != 1 || requests[0].Operation != "payout" || requests[0].Amount != 40 {
+				t.Errorf("gateway received %+v, want one payout of 40", requests)
+			}
+		})
+	}
+}
+
+func TestWithdrawalWalletTooLow(t *testing.T) {
+	s, repo, fake := newTestService(t)
+	repo.balances[testUserUUID] = 10
+
+	if _, err := s.Withdrawal(context.Background(), "token", "4000000000000002", 40); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("Withdrawal error = %v, want %v", err, ErrInsufficientFunds)
+	}
+	if requests := fake.Requests(); len(requests) != 0 {
+		t.Errorf("gateway received %+v, want nothing", requests)
+	}
+}
+
+func TestReplenishmentOutcomes(t *testing.T) {
+	tests := []struct {
+		outcome     fakegateway.Outcome
+		wantErr     error
+		wantBalance int
+		wantStatus  string
+	}{
+		{fakegateway.Success, nil, 40, "credited"},
+		{fakegateway.NotEnoughMoney, ErrNotEnoughMoney, 0, "released"},
+		// the card may have been charged: keep the deposit on record
+		{fakegateway.ServerError, ErrPaymentFailed, 0, "pending"},
+		{fakegateway.Unreachable, ErrGatewayUnreachable, 0, "pending"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.outcome), func(t *testing.T) {
+			s, repo, fake := newTestService(t)
+			fake.Script("4000000000000002", tt.outcome)
+
+			_, err := s.Replenishment(context.Background(), "token", "4000000000000002", "Test Owner", "123", 40)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Replenishment error = %v, want %v", err, tt.wantErr)
+			}
+			balance, status := repo.state(testUserUUID, repo.deposit(1))
+			if balance != tt.wantBalance || status != tt.wantStatus {
+				t.Errorf("balance %d, deposit %q; want %d, %q", balance, status, tt.wantBalance, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReplenishmentCreditFails(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	repo.failCredit = true
+
+	if _, err := s.Replenishment(context.Background(), "token", "4000000000000002", "Test Owner", "123", 40); err == nil {
+		t.Fatal("Replenishment succeeded without crediting the wallet")
+	}
+	// the card was charged: the deposit waits for finance
+	if balance, status := repo.state(testUserUUID, repo.deposit(1)); balance != 0 || status != "pending" {
+		t.Errorf("balance %d, deposit %q; want 0, pending", balance, status)
+	}
+
+	repo.failCredit = false
+	if err := s.ResolvePendingDeposit(context.Background(), 1, "credit"); err != nil {
+		t.Fatalf("ResolvePendingDeposit: %v", err)
+	}
+	if balance, status := repo.state(testUserUUID, repo.deposit(1)); balance != 40 || status != "credited" {
+		t.Errorf("balance %d, deposit %q; want 40, credited", balance, status)
+	}
+	if err := s.ResolvePendingDeposit(context.Background(), 1, "release"); !errors.Is(err, ErrDepositNotPending) {
+		t.Errorf("second resolve error = %v, want %v", err, ErrDepositNotPending)
+	}
+}
+
+func TestWithdrawalScriptedSequence(t *testing.T) {
+	s, repo, fake := newTestService(t)
+	repo.balances[testUserUUID] = 100
+	if err := fake.ScriptFromString("4000000000009995:unreachable,not_enough_money,success"); err != nil {
+		t.Fatal(err)
+	}
+
+	wantErrs := []error{ErrGatewayUnreachable, ErrNotEnoughMoney, nil}
+	for i, wantErr := range wantErrs {
+		_, err := s.Withdrawal(context.Background(), "token", "4000000000009995", 30)
+		if wantErr == nil && err != nil || wantErr != nil && !errors.Is(err, wantErr) {
+			t.Fatalf("attempt %d: error = %v, want %v", i+1, err, wantErr)
+		}
+	}
+	// the unreachable attempt stays held, the refused one came back
+	if balance, _ := repo.state(testUserUUID, nil); balance != 40 {
+		t.Errorf("balance %d, want 40", balance)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultPaymentGatewayURL is the payment provider used in production
+const DefaultPaymentGatewayURL = "https://arlan-api.azurewebsites.net"
+
+// ErrGatewayUnreachable means no answer came from the provider, so it is not
+// known whether the money moved
+var ErrGatewayUnreachable = errors.New("payment gateway unreachable")
+
+// GatewayError is an answer from the provider that is neither a success nor
+// one of the known refusals
+type GatewayError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *GatewayError) Error() string {
+	return fmt.Sprintf("payment gateway answered %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *GatewayError) Unwrap() error {
+	return ErrPaymentFailed
+}
+
+// Card is what the provider needs to charge a card
+type Card struct {
+	Number string
+	Owner  string
+	CVV    string
+}
+
+// PaymentGateway moves money between cards and the casino. Both operations
+// return nil on success, ErrInvalidCredentialsCard or ErrNotEnoughMoney when
+// the provider refuses, a *GatewayError for any other answer and an error
+// wrapping ErrGatewayUnreachable when there was no answer.
+type PaymentGateway interface {
+	// Charge takes amount from the card
+	Charge(ctx context.Context, card Card, amount int) error
+	// Payout sends amount to the card
+	Payout(ctx context.Context, cardNumber string, amount int) error
+}
+
+// HTTPPaymentGateway talks to the payment provider API
+type HTTPPaymentGateway struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewHTTPPaymentGateway(baseURL string) *HTTPPaymentGateway {
+	return &HTTPPaymentGateway{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (g *HTTPPaymentGateway) Charge(ctx context.Context, card Card, amount int) error {
+	return g.post(ctx, "/api/payment/pay", map[string]interface{}{
+		"cardNumber":    card.Number,
+		"cardOwnerName": card.Owner,
+		"cvv":           card.CVV,
+		"paymentAmount": amount,
+	})
+}
+
+func (g *HTTPPaymentGateway) Payout(ctx context.Context, cardNumber string, amount int) error {
+	return g.post(ctx, "/api/payment/addMoney", map[string]interface{}{
+		"cardNumber":    cardNumber,
+		"paymentAmount": amount,
+	})
+}
+
+// post sends a payment request. The provider reports refusals only as text
+// in a 400 response, which is turned into the typed errors here.
+func (g *HTTPPaymentGateway) post(ctx context.Context, path string, payload map[string]interface{}) error {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrGatewayUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Error reading payment response body: %v", err)
+	}
+	bodyStr := strings.TrimSpace(string(bodyBytes))
+	log.Printf("Payment API response: Path: %s, Status: %d, Body: %s", path, resp.StatusCode, bodyStr)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest && strings.Contains(bodyStr, "Invalid Credentials"):
+		return ErrInvalidCredentialsCard
+	case resp.StatusCode == http.StatusBadRequest && strings.Contains(bodyStr, "Not enough money"):
+		return ErrNotEnoughMoney
+	default:
+		return &GatewayError{StatusCode: resp.StatusCode, Body: bodyStr}
+	}
+}